@@ -4,22 +4,47 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"telegram_bot/structs"
 )
 
+// main migrates the database and runs the bot.
+// "tele_bot migrate [up | down [n] | status]" only manages the migrations.
 func main() {
-	// Read PostgreSQL password from environment variable
-	password := os.Getenv("POSTGRES_PASSWORD")
-	if password == "" {
-		log.Fatal("POSTGRES_PASSWORD environment variable is not set")
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		db := openDB()
+		defer db.Close()
+		if err := migrate(db, os.Args[2:]); err != nil {
+			log.Fatal("Error migrating database:", err)
+		}
+		return
 	}
 
-	// Open database connection with password
-	db, err := structs.NewDB(fmt.Sprintf("postgresql://postgres:%s@31.216.88.247/Telegram_Filter_Bot?sslmode=disable", password))
-	if err != nil {
-		log.Fatal("Error connecting to database:", err)
+	// STORAGE=file keeps everything in a single file instead of Postgres, STORAGE=memory keeps nothing (development)
+	var store structs.Store
+	switch os.Getenv("STORAGE") {
+	case "memory":
+		log.Println("STORAGE is memory, nothing is kept once the bot stops")
+		store = structs.NewMemoryStore()
+	case "file":
+		path := os.Getenv("STORAGE_FILE")
+		if path == "" {
+			path = "tele_bot.data"
+		}
+		fileStore, err := structs.NewFileStore(path)
+		if err != nil {
+			log.Fatal("Error opening storage file:", err)
+		}
+		store = fileStore
+	default:
+		db := openDB()
+		// Create or update the tables before anything uses them
+		if err := db.MigrateUp(); err != nil {
+			log.Fatal("Error migrating database:", err)
+		}
+		store = db
 	}
-	defer db.Close()
+	defer store.Close()
 
 	// Get bot token from environment variable
 	botToken := os.Getenv("BOT_TOKEN")
@@ -28,11 +53,82 @@ func main() {
 	}
 
 	// Initialize the bot
-	bot, err := structs.NewBot(botToken, db)
+	bot, err := structs.NewBot(botToken, store)
 	if err != nil {
 		log.Panic(err)
 	}
 
-	// Bot runs
+	// The bot owner may use every command, including /stop
+	if ownerID := os.Getenv("BOT_OWNER_ID"); ownerID != "" {
+		bot.OwnerID, err = strconv.ParseInt(ownerID, 10, 64)
+		if err != nil {
+			log.Fatal("BOT_OWNER_ID must be a numeric Telegram user ID:", err)
+		}
+	} else {
+		log.Println("BOT_OWNER_ID environment variable is not set, nobody can stop the bot with /stop")
+	}
+
+	// Bot runs until /stop, then the deferred Close is the only one closing the store
 	bot.StartListening()
 }
+
+// openDB connects to PostgreSQL
+func openDB() *structs.DB {
+	// Read PostgreSQL password from environment variable
+	password := os.Getenv("POSTGRES_PASSWORD")
+	if password == "" {
+		log.Fatal("POSTGRES_PASSWORD environment variable is not set")
+	}
+
+	// The database host can be changed, e.g. to the db service of docker-compose
+	host := os.Getenv("POSTGRES_HOST")
+	if host == "" {
+		host = "31.216.88.247"
+	}
+
+	// Open database connection with password
+	db, err := structs.NewDB(fmt.Sprintf("postgresql://postgres:%s@%s/Telegram_Filter_Bot?sslmode=disable", password, host))
+	if err != nil {
+		log.Fatal("Error connecting to database:", err)
+	}
+	return db
+}
+
+// migrate runs the migrate subcommand
+func migrate(db *structs.DB, args []string) error {
+	if len(args) == 0 || args[0] == "up" {
+		return db.MigrateUp()
+	}
+
+	switch args[0] {
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of migrations to revert: %s", args[1])
+			}
+			steps = n
+		}
+		return db.MigrateDown(steps)
+
+	case "status":
+		migrations, applied, err := db.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		appliedAt := make(map[int]string)
+		for _, migration := range applied {
+			appliedAt[migration.Version] = migration.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		for _, migration := range migrations {
+			status := "pending"
+			if at, ok := appliedAt[migration.Version]; ok {
+				status = "applied " + at
+			}
+			fmt.Printf("%04d %-20s %s\n", migration.Version, migration.Name, status)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown migrate command %q, use up, down [n] or status", args[0])
+}
@@ -0,0 +1,248 @@
+package structs
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Statuses of an appeal
+const (
+	AppealPending  = "pending"
+	AppealAccepted = "accepted"
+	AppealDenied   = "denied"
+)
+
+// Appeal is a request of a user to undo a moderation event
+type Appeal struct {
+	ID        int64
+	EventID   int64
+	ChatID    int64
+	UserID    int64
+	Reason    string
+	Status    string
+	DecidedBy int64
+}
+
+// describeEvent returns a short human readable description of a moderation event
+func describeEvent(event ModerationEvent) string {
+	action := "message removed"
+	if event.Action != ActionNone {
+		action = string(event.Action)
+	}
+	return fmt.Sprintf("%s: %s, %s on %s", event.ChatTitle, event.Rule, action, event.CreatedAt.Format("2006-01-02 15:04"))
+}
+
+// Appeal lists the recent moderation events of the user so they can pick one to appeal
+func (b *TeleBot) Appeal(update tgbotapi.Update) {
+	message := update.Message
+
+	events, err := b.DB.UserModerations(message.From.ID, 5)
+	if err != nil {
+		b.reply(message, "Could not load your moderation history. Please try again.")
+		return
+	}
+	if len(events) == 0 {
+		b.reply(message, "No rule was applied to you recently, there is nothing to appeal.")
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, event := range events {
+		button, err := b.callbackButton(describeEvent(event), message.Chat.ID, menuButtonTTL, CallbackAppealEvent, strconv.FormatInt(event.ID, 10))
+		if err != nil {
+			log.Println("Error encoding callback data:", err)
+			continue
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(button))
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, "Which decision do you want to appeal?")
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	b.API.Send(msg)
+}
+
+// HandleAppealEvent asks the user for the reason of their appeal once they picked a moderation event, args hold its ID
+func (b *TeleBot) HandleAppealEvent(update tgbotapi.Update, args []string) {
+	query := update.CallbackQuery
+
+	if len(args) != 1 {
+		return
+	}
+	eventID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return
+	}
+
+	event, err := b.DB.GetModeration(eventID)
+	if err != nil || event == nil || event.UserID != query.From.ID {
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "This decision cannot be appealed."))
+		return
+	}
+	if b.ChatSettings(event.ChatID).Review.ChatID == 0 {
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "This chat does not accept appeals."))
+		return
+	}
+	existing, err := b.DB.PendingAppeal(event.ID)
+	if err != nil {
+		log.Println("Error loading appeal:", err)
+		return
+	}
+	if existing != nil {
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "Your appeal of this decision is waiting for the admins."))
+		return
+	}
+
+	b.API.Request(tgbotapi.NewCallback(query.ID, ""))
+	text := fmt.Sprintf("You are appealing:\n%s\n\nThe rule \"%s\" matched:\n%s", describeEvent(*event), event.Rule, event.Text)
+	b.API.Request(tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text))
+
+	b.StartConversation(query.Message.Chat.ID, query.From.ID, Conversation{
+		Name:    "appeal",
+		Steps:   []Step{{Prompt: "Please send the reason of your appeal in one message.", Validate: NotEmpty}},
+		Timeout: 10 * time.Minute,
+		Done: func(update tgbotapi.Update, answers []string) {
+			b.fileAppeal(update, eventID, answers[0])
+		},
+	})
+}
+
+// fileAppeal stores the appeal of a moderation event with the reason the user sent and forwards it to the admin chat
+func (b *TeleBot) fileAppeal(update tgbotapi.Update, eventID int64, reason string) {
+	message := update.Message
+
+	event, err := b.DB.GetModeration(eventID)
+	if err != nil || event == nil {
+		b.reply(message, "Could not find the decision you are appealing. Please try /appeal again.")
+		return
+	}
+
+	appeal := Appeal{
+		EventID: event.ID,
+		ChatID:  event.ChatID,
+		UserID:  event.UserID,
+		Reason:  reason,
+		Status:  AppealPending,
+	}
+	appeal.ID, err = b.DB.CreateAppeal(appeal)
+	if err != nil {
+		log.Println("Error storing appeal:", err)
+		b.reply(message, "Could not send your appeal. Please try /appeal again.")
+		return
+	}
+	b.storeAppealMessage(appeal.ID, message.From.ID, reason)
+
+	card := fmt.Sprintf("Appeal #%d by %s (%d)\n%s\n\nMatched text:\n%s\n\nReason:\n%s",
+		appeal.ID, message.From.String(), message.From.ID, describeEvent(*event), event.Text, appeal.Reason)
+	reviewChatID := b.ChatSettings(event.ChatID).Review.ChatID
+	id := strconv.FormatInt(appeal.ID, 10)
+	accept, err := b.callbackButton("Accept", reviewChatID, reviewButtonTTL, CallbackAppeal, AppealAccepted, id)
+	if err != nil {
+		log.Println("Error encoding callback data:", err)
+		b.reply(message, "Could not send your appeal. Please try /appeal again.")
+		return
+	}
+	deny, err := b.callbackButton("Deny", reviewChatID, reviewButtonTTL, CallbackAppeal, AppealDenied, id)
+	if err != nil {
+		log.Println("Error encoding callback data:", err)
+		b.reply(message, "Could not send your appeal. Please try /appeal again.")
+		return
+	}
+	msg := tgbotapi.NewMessage(reviewChatID, card)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(accept, deny))
+	if _, err := b.API.Send(msg); err != nil {
+		log.Println("Error sending appeal card:", err)
+	}
+
+	b.reply(message, "Your appeal was sent to the admins. You will get their answer here.")
+}
+
+// HandleAppealDecision applies the decision of an admin pressing Accept or Deny on an appeal card,
+// args are the decision and the appeal ID
+func (b *TeleBot) HandleAppealDecision(update tgbotapi.Update, args []string) {
+	query := update.CallbackQuery
+
+	if len(args) != 2 {
+		return
+	}
+	decision := args[0]
+	id, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || (decision != AppealAccepted && decision != AppealDenied) {
+		return
+	}
+
+	appeal, err := b.DB.GetAppeal(id)
+	if err != nil || appeal == nil {
+		log.Println("Error loading appeal:", err)
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "This appeal no longer exists."))
+		return
+	}
+	if !b.HasRole(query.Message.Chat.ID, query.From.ID, RoleModerator) {
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "Only moderators can decide on appeals."))
+		return
+	}
+	if appeal.Status != AppealPending {
+		b.API.Request(tgbotapi.NewCallback(query.ID, "This appeal was already "+appeal.Status+"."))
+		return
+	}
+
+	answer := "Your appeal was denied by the admins."
+	if decision == AppealAccepted {
+		event, err := b.DB.GetModeration(appeal.EventID)
+		if err != nil || event == nil {
+			log.Println("Error loading moderation event:", err)
+			b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "Could not load the appealed action. Please try again."))
+			return
+		}
+		b.liftModeration(*event)
+		answer = "Your appeal was accepted and your strikes were reset."
+		if event.Action == ActionMute || event.Action == ActionBan {
+			answer = "Your appeal was accepted. Your restriction was lifted and your strikes were reset."
+		}
+	}
+
+	if err := b.DB.DecideAppeal(appeal.ID, decision, query.From.ID); err != nil {
+		log.Println("Error storing appeal decision:", err)
+	}
+	b.storeAppealMessage(appeal.ID, query.From.ID, answer)
+
+	// The user started a private chat with the bot to appeal, so the answer can be sent there
+	b.API.Send(tgbotapi.NewMessage(appeal.UserID, answer))
+
+	b.API.Request(tgbotapi.NewCallback(query.ID, "Appeal "+decision+"."))
+	text := fmt.Sprintf("%s\n\nDecision: %s by %s", query.Message.Text, decision, query.From.String())
+	b.API.Request(tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text))
+}
+
+// liftModeration undoes the action recorded on a moderation event and resets the strikes of the user.
+// Only that action is undone, so a mute from a pending captcha or a lockdown stays in place.
+func (b *TeleBot) liftModeration(event ModerationEvent) {
+	chatID, userID := event.ChatID, event.UserID
+	switch event.Action {
+	case ActionMute:
+		if err := b.unrestrictMember(chatID, userID); err != nil {
+			log.Println("Error lifting restriction:", err)
+		}
+	case ActionBan:
+		unban := tgbotapi.UnbanChatMemberConfig{
+			ChatMemberConfig: tgbotapi.ChatMemberConfig{ChatID: chatID, UserID: userID},
+			OnlyIfBanned:     true,
+		}
+		if _, err := b.API.Request(unban); err != nil {
+			log.Println("Error unbanning user:", err)
+		}
+	}
+	if err := b.DB.ResetStrikes(chatID, userID); err != nil {
+		log.Println("Error resetting strikes:", err)
+	}
+}
+
+// storeAppealMessage adds a message to the stored thread of an appeal
+func (b *TeleBot) storeAppealMessage(appealID int64, senderID int64, text string) {
+	if err := b.DB.AddAppealMessage(appealID, senderID, text, time.Now()); err != nil {
+		log.Println("Error storing appeal message:", err)
+	}
+}
@@ -0,0 +1,170 @@
+package structs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Callback data is signed so that buttons cannot be forged, and bound to the chat they were sent to.
+// Its layout is version|action|chat|expiry|args...|signature, which must fit in Telegram's 64 bytes.
+const (
+	callbackVersion   = "1"
+	callbackSeparator = "|"
+	callbackMaxLength = 64
+	callbackSigLength = 8 // bytes of the HMAC kept in the data
+)
+
+// Actions of the inline buttons
+const (
+	CallbackCaptcha       = "cap"
+	CallbackReview        = "rev"
+	CallbackAppealEvent   = "ape"
+	CallbackAppeal        = "apl"
+	CallbackLockdownLift  = "lck"
+	CallbackShowWith      = "shw"
+	CallbackShowWithout   = "sho"
+	CallbackSettings      = "set"
+	CallbackConnect       = "con"
+	CallbackSearchPage    = "pag"
+	CallbackSubscribe     = "sub"
+	CallbackSubscriptions = "sbs"
+)
+
+// How long buttons can be pressed, decisions are checked against their stored state as well
+const (
+	menuButtonTTL   = time.Hour
+	adminButtonTTL  = 24 * time.Hour
+	reviewButtonTTL = 30 * 24 * time.Hour
+)
+
+// Reasons a button press is refused
+var (
+	ErrCallbackMalformed = errors.New("malformed callback data")
+	ErrCallbackSignature = errors.New("invalid callback signature")
+	ErrCallbackExpired   = errors.New("expired callback data")
+	ErrCallbackChat      = errors.New("callback data of another chat")
+	ErrCallbackTooLong   = errors.New("callback data longer than 64 bytes")
+)
+
+// CallbackPayload is what an inline button carries
+type CallbackPayload struct {
+	Action  string
+	Args    []string
+	ChatID  int64     // chat the button was sent to
+	Expires time.Time // zero means the button never expires
+}
+
+// callbackSigner signs and checks callback data
+type callbackSigner struct {
+	key []byte
+}
+
+// newCallbackSigner derives the signing key from the bot token, which is secret already
+func newCallbackSigner(token string) callbackSigner {
+	key := sha256.Sum256([]byte("callback data:" + token))
+	return callbackSigner{key: key[:]}
+}
+
+// sign returns the truncated HMAC of data
+func (s callbackSigner) sign(data string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:callbackSigLength])
+}
+
+// Encode returns the signed callback data of a payload
+func (s callbackSigner) Encode(payload CallbackPayload) (string, error) {
+	expires := "0"
+	if !payload.Expires.IsZero() {
+		expires = strconv.FormatInt(payload.Expires.Unix(), 36)
+	}
+
+	fields := []string{callbackVersion, payload.Action, strconv.FormatInt(payload.ChatID, 36), expires}
+	for _, arg := range payload.Args {
+		if strings.Contains(arg, callbackSeparator) {
+			return "", fmt.Errorf("callback argument %q contains %q", arg, callbackSeparator)
+		}
+		fields = append(fields, arg)
+	}
+
+	data := strings.Join(fields, callbackSeparator)
+	data += callbackSeparator + s.sign(data)
+	if len(data) > callbackMaxLength {
+		return "", ErrCallbackTooLong
+	}
+	return data, nil
+}
+
+// Decode checks the signature, expiry and chat of callback data pressed in chatID and returns its payload
+func (s callbackSigner) Decode(data string, chatID int64, now time.Time) (CallbackPayload, error) {
+	var payload CallbackPayload
+
+	i := strings.LastIndex(data, callbackSeparator)
+	if i < 0 {
+		return payload, ErrCallbackMalformed
+	}
+	if !hmac.Equal([]byte(s.sign(data[:i])), []byte(data[i+1:])) {
+		return payload, ErrCallbackSignature
+	}
+
+	fields := strings.Split(data[:i], callbackSeparator)
+	if len(fields) < 4 || fields[0] != callbackVersion {
+		return payload, ErrCallbackMalformed
+	}
+
+	var err error
+	payload.Action = fields[1]
+	payload.Args = fields[4:]
+	if payload.ChatID, err = strconv.ParseInt(fields[2], 36, 64); err != nil {
+		return payload, ErrCallbackMalformed
+	}
+	expires, err := strconv.ParseInt(fields[3], 36, 64)
+	if err != nil {
+		return payload, ErrCallbackMalformed
+	}
+	if expires != 0 {
+		payload.Expires = time.Unix(expires, 0)
+		if now.After(payload.Expires) {
+			return payload, ErrCallbackExpired
+		}
+	}
+	if payload.ChatID != chatID {
+		return payload, ErrCallbackChat
+	}
+	return payload, nil
+}
+
+// callbackButton returns an inline button carrying signed data for a chat, valid for ttl (0 means forever).
+// It fails when the data cannot be encoded, a button without data would make Telegram refuse the whole message.
+func (b *TeleBot) callbackButton(label string, chatID int64, ttl time.Duration, action string, args ...string) (tgbotapi.InlineKeyboardButton, error) {
+	payload := CallbackPayload{Action: action, Args: args, ChatID: chatID}
+	if ttl > 0 {
+		payload.Expires = time.Now().Add(ttl)
+	}
+
+	data, err := b.callbacks.Encode(payload)
+	if err != nil {
+		return tgbotapi.InlineKeyboardButton{}, fmt.Errorf("button %q: %w", label, err)
+	}
+	return tgbotapi.NewInlineKeyboardButtonData(label, data), nil
+}
+
+// callbackErrorText returns the alert shown for a refused button press
+func callbackErrorText(err error) string {
+	switch err {
+	case ErrCallbackExpired:
+		return "This button has expired. Please use the command again."
+	case ErrCallbackChat:
+		return "This button does not belong to this chat."
+	default:
+		return "This button is no longer valid. Please use the command again."
+	}
+}
@@ -0,0 +1,108 @@
+package structs
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCallbackButtonTooLong(t *testing.T) {
+	bot, _ := newFakeBot(t)
+
+	button, err := bot.callbackButton("Ok", -100123, time.Hour, CallbackSettings, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if button.CallbackData == nil || *button.CallbackData == "" {
+		t.Error("button without data")
+	}
+
+	if _, err := bot.callbackButton("Too long", -100123, time.Hour, CallbackSettings, strings.Repeat("a", 64)); err == nil {
+		t.Error("button with data over 64 bytes returned")
+	}
+}
+
+func TestCallbackRoundTrip(t *testing.T) {
+	signer := newCallbackSigner("123:token")
+	now := time.Unix(1700000000, 0)
+	payload := CallbackPayload{Action: CallbackReview, Args: []string{ReviewApproved, "12345"}, ChatID: -1001234567890, Expires: now.Add(time.Hour)}
+
+	data, err := signer.Encode(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > callbackMaxLength {
+		t.Errorf("data is %d bytes, more than %d", len(data), callbackMaxLength)
+	}
+
+	decoded, err := signer.Decode(data, payload.ChatID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Action != payload.Action || !reflect.DeepEqual(decoded.Args, payload.Args) || decoded.ChatID != payload.ChatID || !decoded.Expires.Equal(payload.Expires) {
+		t.Errorf("Decode = %+v, want %+v", decoded, payload)
+	}
+
+	// Without expiry the data never expires
+	forever, err := signer.Encode(CallbackPayload{Action: CallbackLockdownLift, ChatID: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signer.Decode(forever, 5, now.AddDate(10, 0, 0)); err != nil {
+		t.Errorf("Decode of data without expiry: %v", err)
+	}
+}
+
+func TestCallbackRefused(t *testing.T) {
+	signer := newCallbackSigner("123:token")
+	now := time.Unix(1700000000, 0)
+	data, err := signer.Encode(CallbackPayload{Action: CallbackSettings, Args: []string{"a", "main"}, ChatID: 5, Expires: now.Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		data   string
+		chatID int64
+		now    time.Time
+		want   error
+	}{
+		{"expired", data, 5, now.Add(2 * time.Minute), ErrCallbackExpired},
+		{"other chat", data, 6, now, ErrCallbackChat},
+		{"tampered", strings.Replace(data, "main", "rules", 1), 5, now, ErrCallbackSignature},
+		{"other key", data, 5, now, ErrCallbackSignature},
+		{"no signature", "garbage", 5, now, ErrCallbackMalformed},
+	}
+	for _, test := range tests {
+		decoder := signer
+		if test.name == "other key" {
+			decoder = newCallbackSigner("456:other")
+		}
+		if _, err := decoder.Decode(test.data, test.chatID, test.now); err != test.want {
+			t.Errorf("%s: Decode error = %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestCallbackEncodeLimits(t *testing.T) {
+	signer := newCallbackSigner("123:token")
+
+	// The largest args that fit, and one byte more
+	base, err := signer.Encode(CallbackPayload{Action: CallbackSettings, ChatID: 5, Args: []string{""}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room := callbackMaxLength - len(base)
+	if _, err := signer.Encode(CallbackPayload{Action: CallbackSettings, ChatID: 5, Args: []string{strings.Repeat("a", room)}}); err != nil {
+		t.Errorf("Encode of exactly %d bytes: %v", callbackMaxLength, err)
+	}
+	if _, err := signer.Encode(CallbackPayload{Action: CallbackSettings, ChatID: 5, Args: []string{strings.Repeat("a", room+1)}}); err != ErrCallbackTooLong {
+		t.Errorf("Encode of %d bytes error = %v, want %v", callbackMaxLength+1, err, ErrCallbackTooLong)
+	}
+
+	if _, err := signer.Encode(CallbackPayload{Action: CallbackSettings, ChatID: 5, Args: []string{"a|b"}}); err == nil {
+		t.Error("Encode of an argument containing the separator succeeded")
+	}
+}
@@ -0,0 +1,315 @@
+package structs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Kinds of CAPTCHA challenge
+const (
+	CaptchaMath  = "math"
+	CaptchaEmoji = "emoji"
+)
+
+// captchaMaxAttempts is the number of wrong answers a new member may give before being kicked
+const captchaMaxAttempts = 3
+
+// captchaCheckInterval is how often expired challenges are looked for
+const captchaCheckInterval = 15 * time.Second
+
+// captchaEmojis are the options of the pick-the-emoji challenge
+var captchaEmojis = []string{"🐶", "🐱", "🍎", "🚗", "⚽", "🌙", "🎸", "🌵"}
+
+// CaptchaSettings configures the verification of new members in a chat
+type CaptchaSettings struct {
+	Enabled bool   `json:"enabled"`
+	Kind    string `json:"kind"`    // CaptchaMath or CaptchaEmoji
+	Timeout int    `json:"timeout"` // seconds a new member has to answer
+}
+
+// CaptchaChallenge is a pending verification of a new member
+type CaptchaChallenge struct {
+	ChatID    int64
+	UserID    int64
+	MessageID int // the message holding the challenge keyboard
+	Answer    string
+	Attempts  int
+	ExpiresAt time.Time
+}
+
+// newCaptcha builds a question, its correct answer and the shuffled options to pick from
+func newCaptcha(kind string) (question string, answer string, options []string) {
+	if kind == CaptchaEmoji {
+		options = make([]string, len(captchaEmojis))
+		copy(options, captchaEmojis)
+		rand.Shuffle(len(options), func(i, j int) { options[i], options[j] = options[j], options[i] })
+		options = options[:6]
+		answer = options[rand.Intn(len(options))]
+		return fmt.Sprintf("pick the %s", answer), answer, options
+	}
+
+	a, b := rand.Intn(10)+1, rand.Intn(10)+1
+	sum := a + b
+	answer = strconv.Itoa(sum)
+	options = []string{answer}
+	for len(options) < 4 {
+		wrong := strconv.Itoa(sum + rand.Intn(9) - 4)
+		duplicate := false
+		for _, option := range options {
+			if option == wrong {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			options = append(options, wrong)
+		}
+	}
+	rand.Shuffle(len(options), func(i, j int) { options[i], options[j] = options[j], options[i] })
+	return fmt.Sprintf("what is %d + %d?", a, b), answer, options
+}
+
+// HandleNewMembers challenges every user added by a new_chat_members service message
+func (b *TeleBot) HandleNewMembers(update tgbotapi.Update) {
+	for _, user := range update.Message.NewChatMembers {
+		user := user
+		b.OnJoin(update.Message.Chat, &user)
+	}
+}
+
+// HandleChatMember challenges users whose chat_member update shows them joining
+func (b *TeleBot) HandleChatMember(update tgbotapi.Update) {
+	change := update.ChatMember
+	wasOutside := change.OldChatMember.HasLeft() || change.OldChatMember.WasKicked()
+	if wasOutside && change.NewChatMember.Status == "member" {
+		b.OnJoin(&change.Chat, change.NewChatMember.User)
+	}
+}
+
+// joinReportWindow is how long a join is remembered, it is reported both as a
+// new_chat_members message and as a chat_member update
+const joinReportWindow = time.Minute
+
+// OnJoin is called for every report of a user joining a chat, and handles each join once
+func (b *TeleBot) OnJoin(chat *tgbotapi.Chat, user *tgbotapi.User) {
+	if user == nil || user.IsBot {
+		return
+	}
+	if b.limiter.Hit(fmt.Sprintf("join:%d:%d", chat.ID, user.ID), joinReportWindow) > 1 {
+		return
+	}
+	b.RecordJoin(chat.ID)
+	if _, err := b.DB.TouchMember(chat.ID, user.ID, time.Now()); err != nil {
+		log.Println("Error storing chat member:", err)
+	}
+
+	// Members stopped by their profile or muted by a lockdown never get a challenge
+	if b.ScreenNewMember(chat, user) || b.RestrictDuringLockdown(chat.ID, user.ID) {
+		return
+	}
+	b.StartCaptcha(chat, user)
+}
+
+// StartCaptcha restricts a new member and posts the challenge they must answer
+func (b *TeleBot) StartCaptcha(chat *tgbotapi.Chat, user *tgbotapi.User) {
+	settings := b.ChatSettings(chat.ID).Captcha
+	if !settings.Enabled {
+		return
+	}
+
+	// Joins are reported both as a service message and as a chat_member update
+	existing, err := b.DB.GetCaptchaChallenge(chat.ID, user.ID)
+	if err != nil {
+		log.Println("Error loading captcha challenge:", err)
+		return
+	}
+	if existing != nil {
+		return
+	}
+
+	question, answer, options := newCaptcha(settings.Kind)
+	// Every option must be a button, a keyboard missing the answer could not be solved
+	var row []tgbotapi.InlineKeyboardButton
+	timeout := time.Duration(settings.Timeout) * time.Second
+	for _, option := range options {
+		button, err := b.callbackButton(option, chat.ID, timeout, CallbackCaptcha, strconv.FormatInt(user.ID, 10), option)
+		if err != nil {
+			log.Println("Error encoding callback data:", err)
+			return
+		}
+		row = append(row, button)
+	}
+
+	challenge := CaptchaChallenge{
+		ChatID:    chat.ID,
+		UserID:    user.ID,
+		Answer:    answer,
+		ExpiresAt: time.Now().Add(timeout),
+	}
+	// The challenge is saved before the member is muted, so ExpireCaptchas always ends the restriction
+	if err := b.DB.SaveCaptchaChallenge(challenge); err != nil {
+		log.Println("Error storing captcha challenge:", err)
+		return
+	}
+	if err := b.restrictMember(chat.ID, user.ID, 0); err != nil {
+		log.Println("Error restricting new member:", err)
+		if err := b.DB.DeleteCaptchaChallenge(chat.ID, user.ID); err != nil {
+			log.Println("Error deleting captcha challenge:", err)
+		}
+		return
+	}
+
+	text := fmt.Sprintf("Welcome %s! To be able to write in this chat, %s\nYou have %d seconds to answer.", user.String(), question, settings.Timeout)
+	msg := tgbotapi.NewMessage(chat.ID, text)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(row)
+	sent, err := b.API.Send(msg)
+	if err != nil {
+		// Without a question to answer, the member is let in rather than kicked when it expires
+		log.Println("Error sending captcha:", err)
+		if err := b.unrestrictMember(chat.ID, user.ID); err != nil {
+			log.Println("Error unrestricting member:", err)
+		}
+		if err := b.DB.DeleteCaptchaChallenge(chat.ID, user.ID); err != nil {
+			log.Println("Error deleting captcha challenge:", err)
+		}
+		return
+	}
+
+	challenge.MessageID = sent.MessageID
+	if err := b.DB.SaveCaptchaChallenge(challenge); err != nil {
+		log.Println("Error storing captcha challenge:", err)
+	}
+}
+
+// HandleCaptchaAnswer checks a button press on a challenge keyboard, args are the user ID and the chosen option
+func (b *TeleBot) HandleCaptchaAnswer(update tgbotapi.Update, args []string) {
+	query := update.CallbackQuery
+	chatID := query.Message.Chat.ID
+
+	if len(args) != 2 {
+		return
+	}
+	userID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return
+	}
+
+	if query.From.ID != userID {
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "This challenge is not for you."))
+		return
+	}
+
+	challenge, err := b.DB.GetCaptchaChallenge(chatID, userID)
+	if err != nil {
+		log.Println("Error loading captcha challenge:", err)
+		return
+	}
+	if challenge == nil {
+		b.API.Request(tgbotapi.NewCallback(query.ID, "This challenge has expired."))
+		return
+	}
+
+	if args[1] == challenge.Answer {
+		b.API.Request(tgbotapi.NewCallback(query.ID, "Correct, welcome!"))
+		b.passCaptcha(*challenge)
+		return
+	}
+
+	challenge.Attempts++
+	if challenge.Attempts >= captchaMaxAttempts {
+		b.API.Request(tgbotapi.NewCallback(query.ID, "Wrong answer."))
+		b.failCaptcha(*challenge)
+		return
+	}
+
+	if err := b.DB.SaveCaptchaChallenge(*challenge); err != nil {
+		log.Println("Error storing captcha challenge:", err)
+	}
+	left := captchaMaxAttempts - challenge.Attempts
+	b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, fmt.Sprintf("Wrong answer, %d attempts left.", left)))
+}
+
+// passCaptcha lifts the restriction of a member who answered correctly
+func (b *TeleBot) passCaptcha(challenge CaptchaChallenge) {
+	if err := b.unrestrictMember(challenge.ChatID, challenge.UserID); err != nil {
+		log.Println("Error lifting restriction:", err)
+		return
+	}
+	b.finishCaptcha(challenge)
+}
+
+// failCaptcha kicks a member who answered wrong too often or did not answer in time
+func (b *TeleBot) failCaptcha(challenge CaptchaChallenge) {
+	if err := b.kickMember(challenge.ChatID, challenge.UserID); err != nil {
+		log.Println("Error kicking member:", err)
+	}
+	b.finishCaptcha(challenge)
+}
+
+// finishCaptcha removes the challenge message and forgets the challenge
+func (b *TeleBot) finishCaptcha(challenge CaptchaChallenge) {
+	deleteConfig := tgbotapi.NewDeleteMessage(challenge.ChatID, challenge.MessageID)
+	if _, err := b.API.Request(deleteConfig); err != nil {
+		log.Println("Error deleting captcha message:", err)
+	}
+	if err := b.DB.DeleteCaptchaChallenge(challenge.ChatID, challenge.UserID); err != nil {
+		log.Println("Error deleting captcha challenge:", err)
+	}
+}
+
+// ExpireCaptchas kicks members whose challenge timed out.
+// Challenges live in the database, so the ones that expired while the bot was down are handled on startup.
+func (b *TeleBot) ExpireCaptchas(ctx context.Context) {
+	for {
+		challenges, err := b.DB.ExpiredCaptchaChallenges(time.Now())
+		if err != nil {
+			log.Println("Error loading expired captcha challenges:", err)
+		}
+		for _, challenge := range challenges {
+			b.failCaptcha(challenge)
+		}
+		if !sleepContext(ctx, captchaCheckInterval) {
+			return
+		}
+	}
+}
+
+// Captcha turns new member verification on or off, or picks the kind of challenge
+func (b *TeleBot) Captcha(update tgbotapi.Update) {
+	message := update.Message
+
+	settings := b.ChatSettings(message.Chat.ID)
+	switch strings.ToLower(strings.TrimSpace(message.CommandArguments())) {
+	case "on":
+		settings.Captcha.Enabled = true
+	case "off":
+		settings.Captcha.Enabled = false
+	case CaptchaMath:
+		settings.Captcha.Enabled = true
+		settings.Captcha.Kind = CaptchaMath
+	case CaptchaEmoji:
+		settings.Captcha.Enabled = true
+		settings.Captcha.Kind = CaptchaEmoji
+	default:
+		b.reply(message, "Usage: /captcha on|off|math|emoji")
+		return
+	}
+
+	if err := b.SaveChatSettings(message.Chat.ID, settings); err != nil {
+		b.reply(message, "Could not save the captcha settings. Please try again.")
+		return
+	}
+
+	if settings.Captcha.Enabled {
+		b.reply(message, fmt.Sprintf("New members now have to solve a %s challenge.", settings.Captcha.Kind))
+	} else {
+		b.reply(message, "New member verification is off.")
+	}
+}
@@ -0,0 +1,32 @@
+package structs
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestOnJoinCountsEachJoinOnce(t *testing.T) {
+	bot, _ := newFakeBot(t)
+	chat := &tgbotapi.Chat{ID: -100123, Type: ChatSupergroup, Title: "Group"}
+
+	settings := bot.ChatSettings(chat.ID)
+	settings.Raid.Enabled = true
+	settings.Raid.MaxJoins = 1
+	if err := bot.SaveChatSettings(chat.ID, settings); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same join, reported as a new_chat_members message and as a chat_member update
+	first := &tgbotapi.User{ID: 7, FirstName: "Ali"}
+	bot.OnJoin(chat, first)
+	bot.OnJoin(chat, first)
+	if lockdown, _ := bot.DB.GetLockdown(chat.ID); lockdown != nil {
+		t.Fatal("one join reported twice started a lockdown")
+	}
+
+	bot.OnJoin(chat, &tgbotapi.User{ID: 8, FirstName: "Sara"})
+	if lockdown, _ := bot.DB.GetLockdown(chat.ID); lockdown == nil {
+		t.Fatal("two joins over the limit of one did not start a lockdown")
+	}
+}
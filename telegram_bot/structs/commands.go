@@ -0,0 +1,39 @@
+package structs
+
+// registerCommands builds the command registry and its middleware chain.
+// The order of registration is the order of /help and of the command menus.
+func (b *TeleBot) registerCommands() {
+	r := &Router{}
+
+	r.Use(b.RecoverPanic)
+	r.Use(b.LogCommand)
+	r.Use(b.RateLimitCommands)
+	r.Use(b.CheckChatType)
+	r.Use(b.Authorize)
+
+	r.Register(Command{Name: "start", Description: "Start the bot", Handler: b.Start})
+	r.Register(Command{Name: "help", Description: "Display this help message", Handler: b.Help})
+	r.Register(Command{Name: "cancel", Description: "Cancel the current prompt", Handler: b.Cancel})
+	r.Register(Command{Name: "filter", Description: "Define a filter word", Role: RoleModerator, Connectable: true, Handler: b.Filter})
+	r.Register(Command{Name: "show", Description: "Search the stored messages, e.g. /show from:@ali has:link word", Role: RoleModerator, Connectable: true, Handler: b.Show})
+	r.Register(Command{Name: "banimage", Description: "Reply to a photo to ban it in this chat", Role: RoleModerator, ChatTypes: groupChats, Handler: b.BanImage})
+	r.Register(Command{Name: "banfile", Description: "Reply to a file to ban it in this chat", Role: RoleModerator, ChatTypes: groupChats, Handler: b.BanFile})
+	r.Register(Command{Name: "banstickerset", Description: "Reply to a sticker to ban its whole set in this chat", Role: RoleModerator, ChatTypes: groupChats, Handler: b.BanStickerSet})
+	r.Register(Command{Name: "joinfilter", Description: "Manage the words not allowed in the names of new members", Role: RoleModerator, ChatTypes: groupChats, Handler: b.JoinFilter})
+	r.Register(Command{Name: "trust", Description: "Exempt a user from the filters (reply to their message)", Role: RoleModerator, ChatTypes: groupChats, Handler: b.Trust})
+	r.Register(Command{Name: "untrust", Description: "Remove a user from the trusted users", Role: RoleModerator, ChatTypes: groupChats, Handler: b.Untrust})
+	r.Register(Command{Name: "settings", Description: "Open the settings menu of this chat", Role: RoleAdmin, ChatTypes: groupChats, Connectable: true, Handler: b.Settings})
+	r.Register(Command{Name: "captcha", Description: "Turn new member verification on or off", Role: RoleAdmin, ChatTypes: groupChats, Handler: b.Captcha})
+	r.Register(Command{Name: "raid", Description: "Configure raid detection and lockdowns", Role: RoleAdmin, ChatTypes: groupChats, Handler: b.Raid})
+	r.Register(Command{Name: "review", Description: "Send rule matches to an admin chat for review", Role: RoleAdmin, ChatTypes: groupChats, Handler: b.Review})
+	r.Register(Command{Name: "mod", Description: "Make a user a moderator (reply to their message)", Role: RoleAdmin, ChatTypes: groupChats, Handler: b.Mod})
+	r.Register(Command{Name: "unmod", Description: "Remove the moderator role of a user", Role: RoleAdmin, ChatTypes: groupChats, Handler: b.Unmod})
+	r.Register(Command{Name: "subscribe", Description: "Get a message when a query finds new messages in your group", ChatTypes: []string{ChatPrivate}, Handler: b.Subscribe})
+	r.Register(Command{Name: "subscriptions", Description: "List, switch to a daily digest or remove your subscriptions", ChatTypes: []string{ChatPrivate}, Handler: b.Subscriptions})
+	r.Register(Command{Name: "appeal", Description: "Appeal a removed message or a restriction", ChatTypes: []string{ChatPrivate}, Handler: b.Appeal})
+	r.Register(Command{Name: "connect", Description: "Manage a group from your private chat with the bot", Handler: b.Connect})
+	r.Register(Command{Name: "disconnect", Description: "Stop managing the connected group", ChatTypes: []string{ChatPrivate}, Handler: b.Disconnect})
+	r.Register(Command{Name: "stop", Description: "Stop the bot", Role: RoleOwner, Handler: b.Stop})
+
+	b.router = r
+}
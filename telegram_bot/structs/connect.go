@@ -0,0 +1,217 @@
+package structs
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Connection links the private chat of a group admin to a group, so the group can be managed without spamming it
+type Connection struct {
+	UserID        int64
+	ChatID        int64
+	ChatTitle     string
+	Active        bool // the group the user's commands apply to, one per user
+	ConnectedDate time.Time
+}
+
+// TargetChat returns the chat a command applies to: the chat it was sent in, or the connected group in a private chat
+func (b *TeleBot) TargetChat(message *tgbotapi.Message) int64 {
+	if chatID, ok := b.connectedChat(message); ok {
+		return chatID
+	}
+	return message.Chat.ID
+}
+
+// connectedChat returns the group the sender of a private message is connected to
+func (b *TeleBot) connectedChat(message *tgbotapi.Message) (int64, bool) {
+	if message.Chat.Type != ChatPrivate || message.From == nil {
+		return 0, false
+	}
+	connection, err := b.DB.ActiveConnection(message.From.ID)
+	if err != nil {
+		log.Println("Error loading connection:", err)
+		return 0, false
+	}
+	if connection == nil {
+		return 0, false
+	}
+	return connection.ChatID, true
+}
+
+// isGroupAdmin asks Telegram whether a user is the creator or an administrator of a group
+func (b *TeleBot) isGroupAdmin(chatID int64, userID int64) (bool, error) {
+	member, err := b.API.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID},
+	})
+	if err != nil {
+		return false, err
+	}
+	return member.IsCreator() || member.IsAdministrator(), nil
+}
+
+// Connect links the sender's private chat to a group.
+// In a group it connects to that group, in a private chat it takes a group ID or shows the connected groups.
+func (b *TeleBot) Connect(update tgbotapi.Update) {
+	message := update.Message
+
+	if message.Chat.Type != ChatPrivate {
+		b.connectFromGroup(message)
+		return
+	}
+
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 {
+		text, keyboard := b.connectionsMenu(message.Chat.ID, message.From.ID)
+		msg := tgbotapi.NewMessage(message.Chat.ID, text)
+		if keyboard != nil {
+			msg.ReplyMarkup = *keyboard
+		}
+		b.API.Send(msg)
+		return
+	}
+
+	chatID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		b.reply(message, "Please provide the numeric ID of the group, or send /connect in the group itself.")
+		return
+	}
+	chat, err := b.API.GetChat(tgbotapi.ChatInfoConfig{ChatConfig: tgbotapi.ChatConfig{ChatID: chatID}})
+	if err != nil || chat.Type == ChatPrivate {
+		b.reply(message, "I am not a member of that group.")
+		return
+	}
+	if err := b.connect(message.From, &chat); err != nil {
+		b.reply(message, err.Error())
+		return
+	}
+	b.reply(message, connectedText(chat.Title))
+}
+
+// connectFromGroup connects the sender to the group the command was sent in and confirms it privately
+func (b *TeleBot) connectFromGroup(message *tgbotapi.Message) {
+	if err := b.connect(message.From, message.Chat); err != nil {
+		b.reply(message, err.Error())
+		return
+	}
+
+	// Bots can only write to users who started a private chat with them
+	if _, err := b.API.Send(tgbotapi.NewMessage(message.From.ID, connectedText(message.Chat.Title))); err != nil {
+		b.reply(message, fmt.Sprintf("Please start a private chat with @%s, then send /connect here again.", b.API.Self.UserName))
+		return
+	}
+	b.reply(message, "Connected. You can now manage this group from your private chat with me.")
+}
+
+// connect checks that the user administers the group and stores the connection
+func (b *TeleBot) connect(user *tgbotapi.User, chat *tgbotapi.Chat) error {
+	admin, err := b.isGroupAdmin(chat.ID, user.ID)
+	if err != nil {
+		log.Println("Error checking chat member:", err)
+		return errors.New("Could not check your status in that group. Please try again.")
+	}
+	if !admin {
+		return errors.New("Only admins of a group can connect to it.")
+	}
+
+	connection := Connection{UserID: user.ID, ChatID: chat.ID, ChatTitle: chat.Title, ConnectedDate: time.Now()}
+	if err := b.DB.SaveConnection(connection); err != nil {
+		return errors.New("Could not save the connection. Please try again.")
+	}
+	return nil
+}
+
+// connectedText confirms a connection in the private chat of the admin
+func connectedText(title string) string {
+	return fmt.Sprintf("You are now managing %s from here. /filter, /settings and /show apply to it.\n"+
+		"Use /connect to switch between your groups and /disconnect to stop.", title)
+}
+
+// connectionsMenu returns the groups a user is connected to with a button to switch to each of them
+func (b *TeleBot) connectionsMenu(chatID int64, userID int64) (string, *tgbotapi.InlineKeyboardMarkup) {
+	connections, err := b.DB.Connections(userID)
+	if err != nil {
+		log.Println("Error loading connections:", err)
+		return "Could not load your groups. Please try again.", nil
+	}
+	if len(connections) == 0 {
+		return "You are not connected to any group. Send /connect in a group where you are an admin, or /connect <group ID> here.", nil
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, connection := range connections {
+		label := connection.ChatTitle
+		if connection.Active {
+			label = "✅ " + label
+		}
+		button, err := b.callbackButton(label, chatID, menuButtonTTL, CallbackConnect, strconv.FormatInt(connection.ChatID, 36))
+		if err != nil {
+			log.Println("Error encoding callback data:", err)
+			continue
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(button))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return "Your groups. Press one to manage it from here.", &keyboard
+}
+
+// HandleConnectButton switches the group an admin manages from their private chat, args hold the group ID
+func (b *TeleBot) HandleConnectButton(update tgbotapi.Update, args []string) {
+	query := update.CallbackQuery
+
+	if len(args) != 1 {
+		return
+	}
+	chatID, err := strconv.ParseInt(args[0], 36, 64)
+	if err != nil {
+		return
+	}
+
+	// Admin rights may have been taken away since the group was connected
+	admin, err := b.isGroupAdmin(chatID, query.From.ID)
+	if err != nil {
+		log.Println("Error checking chat member:", err)
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "Could not check your status in that group. Please try again."))
+		return
+	}
+	if !admin {
+		if err := b.DB.DeleteConnection(query.From.ID, chatID); err != nil {
+			log.Println("Error deleting connection:", err)
+		}
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "You are no longer an admin of that group, it was removed from your list."))
+	} else if err := b.DB.SetActiveConnection(query.From.ID, chatID); err != nil {
+		log.Println("Error switching connection:", err)
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "Could not switch to that group. Please try again."))
+		return
+	} else {
+		b.API.Request(tgbotapi.NewCallback(query.ID, "Switched."))
+	}
+
+	text, keyboard := b.connectionsMenu(query.Message.Chat.ID, query.From.ID)
+	if keyboard == nil {
+		b.API.Request(tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text))
+		return
+	}
+	b.API.Request(tgbotapi.NewEditMessageTextAndMarkup(query.Message.Chat.ID, query.Message.MessageID, text, *keyboard))
+}
+
+// Disconnect stops managing the connected group from the private chat
+func (b *TeleBot) Disconnect(update tgbotapi.Update) {
+	message := update.Message
+
+	chatID, ok := b.connectedChat(message)
+	if !ok {
+		b.reply(message, "You are not connected to any group.")
+		return
+	}
+	if err := b.DB.DeleteConnection(message.From.ID, chatID); err != nil {
+		b.reply(message, "Could not remove the connection. Please try again.")
+		return
+	}
+	b.reply(message, "Disconnected. Commands sent here apply to this chat again, use /connect to pick another group.")
+}
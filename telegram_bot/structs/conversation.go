@@ -0,0 +1,208 @@
+package structs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Defaults of a conversation that does not set its own
+const (
+	defaultConversationTimeout = 2 * time.Minute
+	defaultConversationRetries = 2
+	conversationSweepInterval  = time.Minute
+)
+
+// Step is one question of a conversation
+type Step struct {
+	Prompt string
+	// Validate checks the answer and returns the value to keep.
+	// The message of a returned error is shown to the user, who may then try again.
+	Validate func(text string) (string, error)
+}
+
+// Conversation is a multi-step exchange with one user in one chat, such as the /filter prompt
+type Conversation struct {
+	Name       string
+	Steps      []Step
+	MaxRetries int           // invalid answers allowed per step, defaultConversationRetries if 0
+	Timeout    time.Duration // time allowed per step, defaultConversationTimeout if 0
+	// Done is called with the validated answers, in step order, and the update holding the last one
+	Done func(update tgbotapi.Update, answers []string)
+}
+
+// session is the state of a running conversation
+type session struct {
+	conversation Conversation
+	step         int
+	answers      []string
+	retries      int
+	expires      time.Time
+}
+
+// sessionKey identifies the conversation of a user in a chat
+type sessionKey struct {
+	chatID int64
+	userID int64
+}
+
+// sessions holds the running conversations
+type sessions struct {
+	mu      sync.Mutex
+	running map[sessionKey]*session
+}
+
+// OneWord validates an answer made of exactly one word
+func OneWord(text string) (string, error) {
+	words := strings.Fields(text)
+	if len(words) != 1 {
+		return "", errors.New("Please provide only one word.")
+	}
+	return words[0], nil
+}
+
+// NotEmpty validates an answer that contains some text
+func NotEmpty(text string) (string, error) {
+	if strings.TrimSpace(text) == "" {
+		return "", errors.New("Please answer with a text message.")
+	}
+	return text, nil
+}
+
+// StartConversation starts a conversation with a user in a chat and asks its first question.
+// A conversation already running for the user in that chat is replaced.
+func (b *TeleBot) StartConversation(chatID int64, userID int64, conversation Conversation) {
+	if conversation.Timeout == 0 {
+		conversation.Timeout = defaultConversationTimeout
+	}
+	if conversation.MaxRetries == 0 {
+		conversation.MaxRetries = defaultConversationRetries
+	}
+
+	state := &session{conversation: conversation, expires: time.Now().Add(conversation.Timeout)}
+
+	b.sessions.mu.Lock()
+	if b.sessions.running == nil {
+		b.sessions.running = make(map[sessionKey]*session)
+	}
+	b.sessions.running[sessionKey{chatID, userID}] = state
+	b.sessions.mu.Unlock()
+
+	b.prompt(chatID, conversation.Steps[0].Prompt)
+}
+
+// Converse passes a message to the conversation its sender is having in the chat.
+// It returns true if the message was an answer, false if it should be handled as a normal message.
+func (b *TeleBot) Converse(update tgbotapi.Update) bool {
+	message := update.Message
+	if message.From == nil {
+		return false
+	}
+	key := sessionKey{message.Chat.ID, message.From.ID}
+
+	// The session is only read and changed under the lock, the answers are sent after it is released
+	b.sessions.mu.Lock()
+	state, ok := b.sessions.running[key]
+	if !ok {
+		b.sessions.mu.Unlock()
+		return false
+	}
+	conversation := state.conversation
+	if time.Now().After(state.expires) {
+		delete(b.sessions.running, key)
+		b.sessions.mu.Unlock()
+		b.reply(message, fmt.Sprintf("The /%s prompt timed out.", conversation.Name))
+		return false
+	}
+
+	step := conversation.Steps[state.step]
+	value, err := step.Validate(message.Text)
+	if err != nil {
+		state.retries++
+		if state.retries > conversation.MaxRetries {
+			delete(b.sessions.running, key)
+			b.sessions.mu.Unlock()
+			b.reply(message, fmt.Sprintf("%s Too many invalid answers, use /%s to try again.", err.Error(), conversation.Name))
+			return true
+		}
+		state.expires = time.Now().Add(conversation.Timeout)
+		b.sessions.mu.Unlock()
+		b.reply(message, fmt.Sprintf("%s %s", err.Error(), step.Prompt))
+		return true
+	}
+
+	state.answers = append(state.answers, value)
+	state.step++
+	state.retries = 0
+	state.expires = time.Now().Add(conversation.Timeout)
+	answers := state.answers
+	finished := state.step == len(conversation.Steps)
+	if finished {
+		delete(b.sessions.running, key)
+	} else {
+		step = conversation.Steps[state.step]
+	}
+	b.sessions.mu.Unlock()
+
+	if !finished {
+		b.prompt(message.Chat.ID, step.Prompt)
+		return true
+	}
+	conversation.Done(update, answers)
+	return true
+}
+
+// ExpireConversations periodically forgets the conversations whose user stopped answering
+// and tells them the prompt timed out
+func (b *TeleBot) ExpireConversations(ctx context.Context) {
+	for sleepContext(ctx, conversationSweepInterval) {
+		for key, name := range b.expiredConversations(time.Now()) {
+			b.API.Send(tgbotapi.NewMessage(key.chatID, fmt.Sprintf("The /%s prompt timed out.", name)))
+		}
+	}
+}
+
+// expiredConversations removes the conversations expired at now and returns their names
+func (b *TeleBot) expiredConversations(now time.Time) map[sessionKey]string {
+	b.sessions.mu.Lock()
+	defer b.sessions.mu.Unlock()
+
+	expired := make(map[sessionKey]string)
+	for key, state := range b.sessions.running {
+		if now.After(state.expires) {
+			expired[key] = state.conversation.Name
+			delete(b.sessions.running, key)
+		}
+	}
+	return expired
+}
+
+// endConversation forgets the conversation of a user in a chat and reports whether there was one
+func (b *TeleBot) endConversation(key sessionKey) bool {
+	b.sessions.mu.Lock()
+	defer b.sessions.mu.Unlock()
+	_, ok := b.sessions.running[key]
+	delete(b.sessions.running, key)
+	return ok
+}
+
+// prompt asks a question of a conversation
+func (b *TeleBot) prompt(chatID int64, text string) {
+	msg := tgbotapi.NewMessage(chatID, text+"\nSend /cancel to stop.")
+	b.API.Send(msg)
+}
+
+// Cancel stops the conversation the sender is having in the chat
+func (b *TeleBot) Cancel(update tgbotapi.Update) {
+	message := update.Message
+	if b.endConversation(sessionKey{message.Chat.ID, message.From.ID}) {
+		b.reply(message, "Cancelled.")
+	} else {
+		b.reply(message, "There is nothing to cancel.")
+	}
+}
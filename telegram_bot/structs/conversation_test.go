@@ -0,0 +1,24 @@
+package structs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpiredConversations(t *testing.T) {
+	bot, _ := newFakeBot(t)
+	step := Step{Prompt: "Word?", Validate: OneWord}
+	bot.StartConversation(1, 7, Conversation{Name: "short", Steps: []Step{step}, Timeout: time.Minute})
+	bot.StartConversation(2, 7, Conversation{Name: "long", Steps: []Step{step}, Timeout: time.Hour})
+
+	expired := bot.expiredConversations(time.Now().Add(2 * time.Minute))
+	if len(expired) != 1 || expired[sessionKey{1, 7}] != "short" {
+		t.Errorf("expired = %v, want only the short conversation", expired)
+	}
+	if _, ok := bot.sessions.running[sessionKey{1, 7}]; ok {
+		t.Error("expired conversation still running")
+	}
+	if _, ok := bot.sessions.running[sessionKey{2, 7}]; !ok {
+		t.Error("running conversation removed")
+	}
+}
@@ -2,10 +2,14 @@ package structs
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type DB struct {
@@ -23,18 +27,158 @@ func NewDB(dataSourceName string) (*DB, error) {
 	return &DB{db}, nil
 }
 
-// StoreMessage stores a message in the appropriate table based on whether it contains the filter word
-func (db *DB) StoreMessage(senderID int64, messageText string, sentDate time.Time, filterWord string, tableName string) error {
+// StoreMessage stores a message together with the rule matches found in it.
+// A message stored again, e.g. after an edit, replaces the stored one and its matches.
+func (db *DB) StoreMessage(message StoredMessage) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
 	query := `
-        INSERT INTO ` + tableName + ` (sender_id, message_text, sent_date, filter_word)
-        VALUES ($1, $2, $3, $4)
+        INSERT INTO messages (chat_id, message_id, sender_id, username, sender_name, chat_type, content_type, message_text, reply_to, thread_id, sent_date, search_config)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12::regconfig)
+        ON CONFLICT (chat_id, message_id) DO UPDATE
+        SET message_text = EXCLUDED.message_text, content_type = EXCLUDED.content_type
+        RETURNING id
     `
-	_, err := db.Exec(query, senderID, messageText, sentDate, filterWord)
+	var id int64
+	err = tx.QueryRow(query, message.ChatID, message.MessageID, message.SenderID, message.Username, message.SenderName, message.ChatType,
+		message.ContentType, message.Text, nullInt(message.ReplyTo), nullInt(message.ThreadID), message.SentDate, searchConfig(message.Language)).Scan(&id)
 	if err != nil {
-		log.Printf("Error storing message in %s table: %v\n", tableName, err)
+		log.Printf("Error storing message: %v\n", err)
+		return err
 	}
-	return err
+
+	if _, err := tx.Exec("DELETE FROM message_matches WHERE message_id = $1", id); err != nil {
+		return err
+	}
+	for _, match := range message.Matches {
+		query := "INSERT INTO message_matches (message_id, rule, pattern, start_offset, end_offset) VALUES ($1, $2, $3, $4, $5)"
+		if _, err := tx.Exec(query, id, match.Rule, match.Pattern, match.Start, match.End); err != nil {
+			log.Printf("Error storing message match: %v\n", err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// nullInt stores 0 as NULL, for optional references such as the replied message
+func nullInt(n int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(n), Valid: n != 0}
+}
+
+// storedMessageColumns are the columns of a stored message, in the order of the fields of StoredMessage
+const storedMessageColumns = "m.id, m.chat_id, COALESCE(m.message_id, 0), m.sender_id, m.username, m.sender_name, m.chat_type, m.content_type, m.message_text, COALESCE(m.reply_to, 0), COALESCE(m.thread_id, 0), m.sent_date"
+
+// compiledSearch is a search turned into SQL, its values are kept aside as parameters
+type compiledSearch struct {
+	from       string
+	conditions []string
+	rank       string
+	headline   string
+	args       []interface{}
+}
+
+// param adds a parameter and returns its placeholder
+func (c *compiledSearch) param(value interface{}) string {
+	c.args = append(c.args, value)
+	return "$" + strconv.Itoa(len(c.args))
+}
+
+// compileSearch turns a search into SQL over the messages of some chats.
+// Everything typed by the user is passed as a parameter, never written into the SQL.
+func compileSearch(chatIDs []int64, search SearchQuery) *compiledSearch {
+	c := &compiledSearch{from: "messages m", rank: "0::real", headline: "m.message_text"}
+	c.conditions = []string{"m.chat_id = ANY(" + c.param(pq.Array(chatIDs)) + ")"}
+
+	// Words and phrases are normalized like the indexed text
+	if search.HasText() {
+		config := c.param(searchConfig(search.Language)) + "::regconfig"
+		var tsQueries []string
+		if len(search.Terms) > 0 {
+			tsQueries = append(tsQueries, fmt.Sprintf("to_tsquery(%s, persian_normalize(%s))", config, c.param(tsQuery(search.Terms))))
+		}
+		for _, phrase := range search.Phrases {
+			tsQueries = append(tsQueries, fmt.Sprintf("phraseto_tsquery(%s, persian_normalize(%s))", config, c.param(phrase)))
+		}
+		c.from += ", (SELECT " + strings.Join(tsQueries, " && ") + ") AS s(q)"
+		c.conditions = append(c.conditions, "m.search_vector @@ s.q")
+		c.rank = "ts_rank(m.search_vector, s.q)"
+		c.headline = "ts_headline(m.search_config, m.message_text, s.q, " + c.param(headlineOptions) + ")"
+	}
+
+	if search.FromID != 0 {
+		c.conditions = append(c.conditions, "m.sender_id = "+c.param(search.FromID))
+	}
+	if search.FromUsername != "" {
+		c.conditions = append(c.conditions, "lower(m.username) = lower("+c.param(search.FromUsername)+")")
+	}
+	if !search.After.IsZero() {
+		c.conditions = append(c.conditions, "m.sent_date >= "+c.param(search.After))
+	}
+	if !search.Before.IsZero() {
+		c.conditions = append(c.conditions, "m.sent_date < "+c.param(search.Before))
+	}
+	for _, matched := range search.Matched {
+		p := c.param(matched)
+		c.conditions = append(c.conditions, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM message_matches mm WHERE mm.message_id = m.id AND (lower(mm.rule) = lower(%s) OR lower(mm.pattern) = lower(%s)))", p, p))
+	}
+	if search.Unmatched {
+		c.conditions = append(c.conditions, "NOT EXISTS (SELECT 1 FROM message_matches mm WHERE mm.message_id = m.id)")
+	}
+	for _, has := range search.Has {
+		if has == "link" {
+			c.conditions = append(c.conditions, "m.message_text ~* "+c.param(linkPattern))
+		} else {
+			c.conditions = append(c.conditions, "m.content_type = "+c.param(has))
+		}
+	}
+	return c
+}
+
+// SearchMessages returns a page of the stored messages of some chats found by a search, best matches first.
+// The page starts after the cursor of the last result of the previous page, or at the first result if after is nil.
+func (db *DB) SearchMessages(chatIDs []int64, search SearchQuery, after *SearchCursor, limit int) ([]SearchResult, error) {
+	c := compileSearch(chatIDs, search)
+	if after != nil {
+		c.conditions = append(c.conditions, fmt.Sprintf("(%s, m.sent_date, m.id) < (%s::real, %s, %s)",
+			c.rank, c.param(after.Rank), c.param(after.SentDate), c.param(after.ID)))
+	}
+	query := `
+        SELECT ` + storedMessageColumns + `, ` + c.rank + ` AS rank, ` + c.headline + `
+        FROM ` + c.from + `
+        WHERE ` + strings.Join(c.conditions, " AND ") + `
+        ORDER BY rank DESC, m.sent_date DESC, m.id DESC
+        LIMIT ` + c.param(limit)
+	rows, err := db.QueryRows(query, c.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var result SearchResult
+		message := &result.StoredMessage
+		err := rows.Scan(&message.ID, &message.ChatID, &message.MessageID, &message.SenderID, &message.Username, &message.SenderName, &message.ChatType,
+			&message.ContentType, &message.Text, &message.ReplyTo, &message.ThreadID, &message.SentDate, &result.Rank, &result.Headline)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// CountMessages returns the number of stored messages of some chats found by a search
+func (db *DB) CountMessages(chatIDs []int64, search SearchQuery) (int, error) {
+	c := compileSearch(chatIDs, search)
+	var count int
+	err := db.QueryRow("SELECT count(*) FROM "+c.from+" WHERE "+strings.Join(c.conditions, " AND "), c.args...).Scan(&count)
+	return count, err
 }
 
 // QueryRows executes a SQL query and returns the result rows
@@ -46,3 +190,730 @@ func (db *DB) QueryRows(query string, args ...interface{}) (*sql.Rows, error) {
 	}
 	return rows, nil
 }
+
+// AddBannedImage adds an image hash to a chat's image blocklist
+func (db *DB) AddBannedImage(chatID int64, hash uint64, addedBy int64) error {
+	query := `
+        INSERT INTO banned_images (chat_id, image_hash, added_by, added_date)
+        VALUES ($1, $2, $3, $4)
+    `
+	// Postgres has no unsigned integers, the hash is stored bit for bit in a BIGINT
+	_, err := db.Exec(query, chatID, int64(hash), addedBy, time.Now())
+	if err != nil {
+		log.Printf("Error storing banned image: %v\n", err)
+	}
+	return err
+}
+
+// BannedImages returns the image hashes banned in a chat
+func (db *DB) BannedImages(chatID int64) ([]uint64, error) {
+	rows, err := db.QueryRows("SELECT image_hash FROM banned_images WHERE chat_id = $1", chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []uint64
+	for rows.Next() {
+		var hash int64
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, uint64(hash))
+	}
+	return hashes, rows.Err()
+}
+
+// GetChatSettings returns the stored settings of a chat, or the defaults if it has none
+func (db *DB) GetChatSettings(chatID int64) (ChatSettings, error) {
+	settings := DefaultChatSettings()
+
+	var data []byte
+	err := db.QueryRow("SELECT settings FROM chat_settings WHERE chat_id = $1", chatID).Scan(&data)
+	if err == sql.ErrNoRows {
+		return settings, nil
+	}
+	if err != nil {
+		log.Printf("Error loading chat settings: %v\n", err)
+		return settings, err
+	}
+
+	// Fields missing from older rows keep their default values
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return DefaultChatSettings(), err
+	}
+	return settings, nil
+}
+
+// SaveChatSettings creates or replaces the settings of a chat
+func (db *DB) SaveChatSettings(chatID int64, settings ChatSettings) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        INSERT INTO chat_settings (chat_id, settings)
+        VALUES ($1, $2)
+        ON CONFLICT (chat_id) DO UPDATE SET settings = EXCLUDED.settings
+    `
+	_, err = db.Exec(query, chatID, data)
+	if err != nil {
+		log.Printf("Error storing chat settings: %v\n", err)
+	}
+	return err
+}
+
+// DocumentHash returns the stored SHA-256 of a file, or an empty string if it was never hashed
+func (db *DB) DocumentHash(fileUniqueID string) (string, error) {
+	var hash string
+	err := db.QueryRow("SELECT sha256 FROM document_hashes WHERE file_unique_id = $1", fileUniqueID).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return hash, err
+}
+
+// StoreDocumentHash remembers the SHA-256 of a file so it does not have to be downloaded again
+func (db *DB) StoreDocumentHash(fileUniqueID string, hash string) error {
+	query := `
+        INSERT INTO document_hashes (file_unique_id, sha256, hashed_date)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (file_unique_id) DO NOTHING
+    `
+	_, err := db.Exec(query, fileUniqueID, hash, time.Now())
+	return err
+}
+
+// AddBadFile adds a SHA-256 to a chat's bad file list
+func (db *DB) AddBadFile(chatID int64, hash string, addedBy int64) error {
+	query := `
+        INSERT INTO bad_files (chat_id, sha256, added_by, added_date)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (chat_id, sha256) DO NOTHING
+    `
+	_, err := db.Exec(query, chatID, hash, addedBy, time.Now())
+	if err != nil {
+		log.Printf("Error storing bad file: %v\n", err)
+	}
+	return err
+}
+
+// HasBadFiles reports whether any bad file applies to a chat.
+// Rows with chat_id 0 form the global list shared by every chat.
+func (db *DB) HasBadFiles(chatID int64) (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM bad_files WHERE chat_id IN (0, $1))", chatID).Scan(&exists)
+	return exists, err
+}
+
+// IsBadFile reports whether a SHA-256 is on the global or the chat's bad file list
+func (db *DB) IsBadFile(chatID int64, hash string) (bool, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM bad_files WHERE chat_id IN (0, $1) AND sha256 = $2)", chatID, hash).Scan(&exists)
+	return exists, err
+}
+
+// SaveCaptchaChallenge creates or updates the pending challenge of a new member
+func (db *DB) SaveCaptchaChallenge(challenge CaptchaChallenge) error {
+	query := `
+        INSERT INTO captcha_challenges (chat_id, user_id, message_id, answer, attempts, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (chat_id, user_id) DO UPDATE
+        SET message_id = EXCLUDED.message_id, answer = EXCLUDED.answer,
+            attempts = EXCLUDED.attempts, expires_at = EXCLUDED.expires_at
+    `
+	_, err := db.Exec(query, challenge.ChatID, challenge.UserID, challenge.MessageID, challenge.Answer, challenge.Attempts, challenge.ExpiresAt)
+	return err
+}
+
+// GetCaptchaChallenge returns the pending challenge of a member, or nil if there is none
+func (db *DB) GetCaptchaChallenge(chatID int64, userID int64) (*CaptchaChallenge, error) {
+	challenge := CaptchaChallenge{ChatID: chatID, UserID: userID}
+	query := "SELECT message_id, answer, attempts, expires_at FROM captcha_challenges WHERE chat_id = $1 AND user_id = $2"
+	err := db.QueryRow(query, chatID, userID).Scan(&challenge.MessageID, &challenge.Answer, &challenge.Attempts, &challenge.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// DeleteCaptchaChallenge removes the pending challenge of a member
+func (db *DB) DeleteCaptchaChallenge(chatID int64, userID int64) error {
+	_, err := db.Exec("DELETE FROM captcha_challenges WHERE chat_id = $1 AND user_id = $2", chatID, userID)
+	return err
+}
+
+// ExpiredCaptchaChallenges returns the challenges that were not answered before the given time
+func (db *DB) ExpiredCaptchaChallenges(now time.Time) ([]CaptchaChallenge, error) {
+	rows, err := db.QueryRows("SELECT chat_id, user_id, message_id, answer, attempts, expires_at FROM captcha_challenges WHERE expires_at <= $1", now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var challenges []CaptchaChallenge
+	for rows.Next() {
+		var c CaptchaChallenge
+		if err := rows.Scan(&c.ChatID, &c.UserID, &c.MessageID, &c.Answer, &c.Attempts, &c.ExpiresAt); err != nil {
+			return nil, err
+		}
+		challenges = append(challenges, c)
+	}
+	return challenges, rows.Err()
+}
+
+// SaveLockdown stores a chat lockdown together with the permissions to restore afterwards
+func (db *DB) SaveLockdown(lockdown Lockdown) error {
+	permissions, err := json.Marshal(lockdown.Permissions)
+	if err != nil {
+		return err
+	}
+
+	query := `
+        INSERT INTO lockdowns (chat_id, until, permissions)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (chat_id) DO UPDATE SET until = EXCLUDED.until, permissions = EXCLUDED.permissions
+    `
+	_, err = db.Exec(query, lockdown.ChatID, lockdown.Until, permissions)
+	return err
+}
+
+// GetLockdown returns the lockdown of a chat, or nil if the chat is not locked
+func (db *DB) GetLockdown(chatID int64) (*Lockdown, error) {
+	lockdown := Lockdown{ChatID: chatID}
+	var permissions []byte
+	err := db.QueryRow("SELECT until, permissions FROM lockdowns WHERE chat_id = $1", chatID).Scan(&lockdown.Until, &permissions)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(permissions, &lockdown.Permissions); err != nil {
+		return nil, err
+	}
+	return &lockdown, nil
+}
+
+// DeleteLockdown removes the lockdown of a chat
+func (db *DB) DeleteLockdown(chatID int64) error {
+	_, err := db.Exec("DELETE FROM lockdowns WHERE chat_id = $1", chatID)
+	return err
+}
+
+// ExpiredLockdowns returns the lockdowns whose cool-down ended before the given time
+func (db *DB) ExpiredLockdowns(now time.Time) ([]Lockdown, error) {
+	rows, err := db.QueryRows("SELECT chat_id, until, permissions FROM lockdowns WHERE until <= $1", now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lockdowns []Lockdown
+	for rows.Next() {
+		var lockdown Lockdown
+		var permissions []byte
+		if err := rows.Scan(&lockdown.ChatID, &lockdown.Until, &permissions); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(permissions, &lockdown.Permissions); err != nil {
+			return nil, err
+		}
+		lockdowns = append(lockdowns, lockdown)
+	}
+	return lockdowns, rows.Err()
+}
+
+// ChatsWithRetention returns the retention period in days of every chat that does not keep its messages forever
+func (db *DB) ChatsWithRetention() (map[int64]int, error) {
+	rows, err := db.QueryRows("SELECT chat_id, (settings->>'retention_days')::int FROM chat_settings WHERE COALESCE((settings->>'retention_days')::int, 0) > 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chats := make(map[int64]int)
+	for rows.Next() {
+		var chatID int64
+		var days int
+		if err := rows.Scan(&chatID, &days); err != nil {
+			return nil, err
+		}
+		chats[chatID] = days
+	}
+	return chats, rows.Err()
+}
+
+// PurgeMessages deletes the stored messages of a chat sent before the given time, with their matches
+func (db *DB) PurgeMessages(chatID int64, before time.Time) error {
+	_, err := db.Exec("DELETE FROM messages WHERE chat_id = $1 AND sent_date < $2", chatID, before)
+	if err != nil {
+		log.Printf("Error purging messages: %v\n", err)
+	}
+	return err
+}
+
+// CreateReviewItem stores a message sent to the review queue and returns its ID
+func (db *DB) CreateReviewItem(item ReviewItem) (int64, error) {
+	query := `
+        INSERT INTO review_items (chat_id, message_id, user_id, user_name, rule, message_text, review_chat_id, copy_id, status, created_date)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+        RETURNING id
+    `
+	var id int64
+	err := db.QueryRow(query, item.ChatID, item.MessageID, item.UserID, item.UserName, item.Rule, item.Text,
+		item.ReviewChatID, item.CopyID, item.Status, time.Now()).Scan(&id)
+	return id, err
+}
+
+// GetReviewItem returns a review item, or nil if it does not exist
+func (db *DB) GetReviewItem(id int64) (*ReviewItem, error) {
+	item := ReviewItem{ID: id}
+	query := `
+        SELECT chat_id, message_id, user_id, user_name, rule, message_text, review_chat_id, copy_id, status, decided_by
+        FROM review_items WHERE id = $1
+    `
+	err := db.QueryRow(query, id).Scan(&item.ChatID, &item.MessageID, &item.UserID, &item.UserName, &item.Rule, &item.Text,
+		&item.ReviewChatID, &item.CopyID, &item.Status, &item.DecidedBy)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// DecideReviewItem records the decision taken on a review item
+func (db *DB) DecideReviewItem(id int64, status string, decidedBy int64) error {
+	query := "UPDATE review_items SET status = $1, decided_by = $2, decided_date = $3 WHERE id = $4"
+	_, err := db.Exec(query, status, decidedBy, time.Now(), id)
+	return err
+}
+
+// ReviewStats counts the decisions taken on the review items of a chat, per rule
+func (db *DB) ReviewStats(chatID int64) ([]ReviewStat, error) {
+	query := `
+        SELECT rule,
+               COUNT(*) FILTER (WHERE status = 'approved'),
+               COUNT(*) FILTER (WHERE status = 'rejected'),
+               COUNT(*) FILTER (WHERE status = 'banned'),
+               COUNT(*) FILTER (WHERE status = 'pending')
+        FROM review_items WHERE chat_id = $1
+        GROUP BY rule ORDER BY rule
+    `
+	rows, err := db.QueryRows(query, chatID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []ReviewStat
+	for rows.Next() {
+		var stat ReviewStat
+		if err := rows.Scan(&stat.Rule, &stat.Approved, &stat.Rejected, &stat.Banned, &stat.Pending); err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
+
+// AddStrike adds a strike to a user in a chat and returns their number of strikes
+func (db *DB) AddStrike(chatID int64, userID int64) (int, error) {
+	query := `
+        INSERT INTO strikes (chat_id, user_id, strikes)
+        VALUES ($1, $2, 1)
+        ON CONFLICT (chat_id, user_id) DO UPDATE SET strikes = strikes.strikes + 1
+        RETURNING strikes
+    `
+	var strikes int
+	err := db.QueryRow(query, chatID, userID).Scan(&strikes)
+	return strikes, err
+}
+
+// ResetStrikes clears the strikes of a user in a chat
+func (db *DB) ResetStrikes(chatID int64, userID int64) error {
+	_, err := db.Exec("DELETE FROM strikes WHERE chat_id = $1 AND user_id = $2", chatID, userID)
+	return err
+}
+
+// RecordModeration stores a moderation event and returns its ID
+func (db *DB) RecordModeration(event ModerationEvent) (int64, error) {
+	query := `
+        INSERT INTO moderation_events (chat_id, chat_title, user_id, rule, action, message_text, created_date)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id
+    `
+	var id int64
+	err := db.QueryRow(query, event.ChatID, event.ChatTitle, event.UserID, event.Rule, string(event.Action), event.Text, event.CreatedAt).Scan(&id)
+	return id, err
+}
+
+// moderationColumns are the columns scanned by scanModeration
+const moderationColumns = "id, chat_id, chat_title, user_id, rule, action, message_text, created_date"
+
+// scanModeration reads a moderation event from a row
+func scanModeration(row interface{ Scan(...interface{}) error }) (ModerationEvent, error) {
+	var event ModerationEvent
+	var action string
+	err := row.Scan(&event.ID, &event.ChatID, &event.ChatTitle, &event.UserID, &event.Rule, &action, &event.Text, &event.CreatedAt)
+	event.Action = Action(action)
+	return event, err
+}
+
+// UserModerations returns the latest moderation events of a user across all chats
+func (db *DB) UserModerations(userID int64, limit int) ([]ModerationEvent, error) {
+	query := "SELECT " + moderationColumns + " FROM moderation_events WHERE user_id = $1 ORDER BY created_date DESC LIMIT $2"
+	rows, err := db.QueryRows(query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []ModerationEvent
+	for rows.Next() {
+		event, err := scanModeration(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// GetModeration returns a moderation event, or nil if it does not exist
+func (db *DB) GetModeration(id int64) (*ModerationEvent, error) {
+	event, err := scanModeration(db.QueryRow("SELECT "+moderationColumns+" FROM moderation_events WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// CreateAppeal stores a new appeal and returns its ID
+func (db *DB) CreateAppeal(appeal Appeal) (int64, error) {
+	query := `
+        INSERT INTO appeals (event_id, chat_id, user_id, reason, status, created_date)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        RETURNING id
+    `
+	var id int64
+	err := db.QueryRow(query, appeal.EventID, appeal.ChatID, appeal.UserID, appeal.Reason, appeal.Status, time.Now()).Scan(&id)
+	return id, err
+}
+
+// appealColumns are the columns scanned by scanAppeal
+const appealColumns = "id, event_id, chat_id, user_id, reason, status, decided_by"
+
+// scanAppeal reads an appeal from a row, returning nil if there is none
+func scanAppeal(row *sql.Row) (*Appeal, error) {
+	var appeal Appeal
+	err := row.Scan(&appeal.ID, &appeal.EventID, &appeal.ChatID, &appeal.UserID, &appeal.Reason, &appeal.Status, &appeal.DecidedBy)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &appeal, nil
+}
+
+// GetAppeal returns an appeal, or nil if it does not exist
+func (db *DB) GetAppeal(id int64) (*Appeal, error) {
+	return scanAppeal(db.QueryRow("SELECT "+appealColumns+" FROM appeals WHERE id = $1", id))
+}
+
+// PendingAppeal returns the undecided appeal of a moderation event, or nil if there is none
+func (db *DB) PendingAppeal(eventID int64) (*Appeal, error) {
+	return scanAppeal(db.QueryRow("SELECT "+appealColumns+" FROM appeals WHERE event_id = $1 AND status = 'pending'", eventID))
+}
+
+// DecideAppeal records the decision taken on an appeal
+func (db *DB) DecideAppeal(id int64, status string, decidedBy int64) error {
+	query := "UPDATE appeals SET status = $1, decided_by = $2, decided_date = $3 WHERE id = $4"
+	_, err := db.Exec(query, status, decidedBy, time.Now(), id)
+	return err
+}
+
+// AddAppealMessage adds a message to the thread of an appeal
+func (db *DB) AddAppealMessage(appealID int64, senderID int64, text string, sentDate time.Time) error {
+	query := `
+        INSERT INTO appeal_messages (appeal_id, sender_id, message_text, sent_date)
+        VALUES ($1, $2, $3, $4)
+    `
+	_, err := db.Exec(query, appealID, senderID, text, sentDate)
+	return err
+}
+
+// TouchMember records the first time a user was seen in a chat and returns it
+func (db *DB) TouchMember(chatID int64, userID int64, seen time.Time) (time.Time, error) {
+	query := `
+        INSERT INTO chat_members (chat_id, user_id, first_seen)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (chat_id, user_id) DO UPDATE SET first_seen = chat_members.first_seen
+        RETURNING first_seen
+    `
+	var firstSeen time.Time
+	err := db.QueryRow(query, chatID, userID, seen).Scan(&firstSeen)
+	return firstSeen, err
+}
+
+// MemberChats returns the groups a user was seen in, most recently joined first
+func (db *DB) MemberChats(userID int64) ([]int64, error) {
+	rows, err := db.QueryRows("SELECT chat_id FROM chat_members WHERE user_id = $1 ORDER BY first_seen DESC", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chats []int64
+	for rows.Next() {
+		var chatID int64
+		if err := rows.Scan(&chatID); err != nil {
+			return nil, err
+		}
+		chats = append(chats, chatID)
+	}
+	return chats, rows.Err()
+}
+
+// ForgetMember deletes what is known about a user in a chat they left
+func (db *DB) ForgetMember(chatID int64, userID int64) error {
+	_, err := db.Exec("DELETE FROM chat_members WHERE chat_id = $1 AND user_id = $2", chatID, userID)
+	return err
+}
+
+// GetRole returns the role stored for a user in a chat, RoleUser if there is none
+func (db *DB) GetRole(chatID int64, userID int64) (Role, error) {
+	var role string
+	err := db.QueryRow("SELECT role FROM chat_roles WHERE chat_id = $1 AND user_id = $2", chatID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return RoleUser, nil
+	}
+	if err != nil {
+		return RoleUser, err
+	}
+	return ParseRole(role), nil
+}
+
+// SetRole stores the role of a user in a chat
+func (db *DB) SetRole(chatID int64, userID int64, role Role) error {
+	query := `
+        INSERT INTO chat_roles (chat_id, user_id, role)
+        VALUES ($1, $2, $3)
+        ON CONFLICT (chat_id, user_id) DO UPDATE SET role = EXCLUDED.role
+    `
+	_, err := db.Exec(query, chatID, userID, role.String())
+	if err != nil {
+		log.Printf("Error storing role: %v\n", err)
+	}
+	return err
+}
+
+// DeleteRole removes the stored role of a user in a chat
+func (db *DB) DeleteRole(chatID int64, userID int64) error {
+	_, err := db.Exec("DELETE FROM chat_roles WHERE chat_id = $1 AND user_id = $2", chatID, userID)
+	return err
+}
+
+// SaveConnection links the private chat of a user to a group and makes it the group their commands apply to
+func (db *DB) SaveConnection(connection Connection) error {
+	query := `
+        INSERT INTO connections (user_id, chat_id, chat_title, active, connected_date)
+        VALUES ($1, $2, $3, TRUE, $4)
+        ON CONFLICT (user_id, chat_id) DO UPDATE SET chat_title = EXCLUDED.chat_title, active = TRUE
+    `
+	if _, err := db.Exec(query, connection.UserID, connection.ChatID, connection.ChatTitle, connection.ConnectedDate); err != nil {
+		log.Printf("Error storing connection: %v\n", err)
+		return err
+	}
+	return db.SetActiveConnection(connection.UserID, connection.ChatID)
+}
+
+// SetActiveConnection makes one of the connected groups of a user the one their commands apply to
+func (db *DB) SetActiveConnection(userID int64, chatID int64) error {
+	_, err := db.Exec("UPDATE connections SET active = (chat_id = $2) WHERE user_id = $1", userID, chatID)
+	return err
+}
+
+// Connections returns the groups a user is connected to
+func (db *DB) Connections(userID int64) ([]Connection, error) {
+	rows, err := db.QueryRows("SELECT user_id, chat_id, chat_title, active, connected_date FROM connections WHERE user_id = $1 ORDER BY chat_title", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var connections []Connection
+	for rows.Next() {
+		var connection Connection
+		if err := rows.Scan(&connection.UserID, &connection.ChatID, &connection.ChatTitle, &connection.Active, &connection.ConnectedDate); err != nil {
+			return nil, err
+		}
+		connections = append(connections, connection)
+	}
+	return connections, rows.Err()
+}
+
+// ActiveConnection returns the group the commands of a user apply to, or nil if they are not connected
+func (db *DB) ActiveConnection(userID int64) (*Connection, error) {
+	connection := Connection{UserID: userID, Active: true}
+	err := db.QueryRow("SELECT chat_id, chat_title, connected_date FROM connections WHERE user_id = $1 AND active", userID).
+		Scan(&connection.ChatID, &connection.ChatTitle, &connection.ConnectedDate)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &connection, nil
+}
+
+// DeleteConnection unlinks the private chat of a user from a group
+func (db *DB) DeleteConnection(userID int64, chatID int64) error {
+	_, err := db.Exec("DELETE FROM connections WHERE user_id = $1 AND chat_id = $2", userID, chatID)
+	return err
+}
+
+// CreateSubscription stores a search a user subscribed to and returns its ID
+func (db *DB) CreateSubscription(subscription Subscription) (int64, error) {
+	query := `
+        INSERT INTO subscriptions (user_id, chat_id, chat_title, query, digest, created_date, last_digest)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+        RETURNING id
+    `
+	var id int64
+	err := db.QueryRow(query, subscription.UserID, subscription.ChatID, subscription.ChatTitle, subscription.Query,
+		subscription.Digest, subscription.CreatedDate, subscription.LastDigest).Scan(&id)
+	return id, err
+}
+
+// subscriptionColumns are the columns scanned by scanSubscription
+const subscriptionColumns = "id, user_id, chat_id, chat_title, query, digest, created_date, last_digest"
+
+// scanSubscription reads a subscription from a row of one of the queries selecting subscriptionColumns
+func scanSubscription(scan func(dest ...interface{}) error) (Subscription, error) {
+	var s Subscription
+	err := scan(&s.ID, &s.UserID, &s.ChatID, &s.ChatTitle, &s.Query, &s.Digest, &s.CreatedDate, &s.LastDigest)
+	return s, err
+}
+
+// GetSubscription returns a subscription, or nil if it does not exist
+func (db *DB) GetSubscription(id int64) (*Subscription, error) {
+	subscription, err := scanSubscription(db.QueryRow("SELECT "+subscriptionColumns+" FROM subscriptions WHERE id = $1", id).Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// querySubscriptions returns the subscriptions selected by a query of subscriptionColumns
+func (db *DB) querySubscriptions(query string, args ...interface{}) ([]Subscription, error) {
+	rows, err := db.QueryRows(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []Subscription
+	for rows.Next() {
+		subscription, err := scanSubscription(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, rows.Err()
+}
+
+// UserSubscriptions returns the subscriptions of a user, oldest first
+func (db *DB) UserSubscriptions(userID int64) ([]Subscription, error) {
+	return db.querySubscriptions("SELECT "+subscriptionColumns+" FROM subscriptions WHERE user_id = $1 ORDER BY id", userID)
+}
+
+// ChatSubscriptions returns the subscriptions to the messages of a chat
+func (db *DB) ChatSubscriptions(chatID int64) ([]Subscription, error) {
+	return db.querySubscriptions("SELECT "+subscriptionColumns+" FROM subscriptions WHERE chat_id = $1 ORDER BY id", chatID)
+}
+
+// SetSubscriptionDigest switches a subscription between immediate alerts and a digest
+func (db *DB) SetSubscriptionDigest(id int64, digest bool) error {
+	_, err := db.Exec("UPDATE subscriptions SET digest = $2 WHERE id = $1", id, digest)
+	return err
+}
+
+// DeleteSubscription removes a subscription and the matches waiting for its digest
+func (db *DB) DeleteSubscription(id int64) error {
+	_, err := db.Exec("DELETE FROM subscriptions WHERE id = $1", id)
+	return err
+}
+
+// AddSubscriptionMatch keeps a message found by a subscription until its digest is sent
+func (db *DB) AddSubscriptionMatch(match SubscriptionMatch) error {
+	query := `
+        INSERT INTO subscription_matches (subscription_id, message_id, sender_id, sender_name, username, chat_type, headline, sent_date)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+    `
+	result := match.Result
+	_, err := db.Exec(query, match.SubscriptionID, result.MessageID, result.SenderID, result.SenderName, result.Username,
+		result.ChatType, result.Headline, result.SentDate)
+	if err != nil {
+		log.Printf("Error storing subscription match: %v\n", err)
+	}
+	return err
+}
+
+// SubscriptionMatches returns the matches waiting for the digest of a subscription, oldest first
+func (db *DB) SubscriptionMatches(subscriptionID int64) ([]SubscriptionMatch, error) {
+	query := `
+        SELECT m.id, m.subscription_id, s.chat_id, m.message_id, m.sender_id, m.sender_name, m.username, m.chat_type, m.headline, m.sent_date
+        FROM subscription_matches m JOIN subscriptions s ON s.id = m.subscription_id
+        WHERE m.subscription_id = $1
+        ORDER BY m.id
+    `
+	rows, err := db.QueryRows(query, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matches []SubscriptionMatch
+	for rows.Next() {
+		var match SubscriptionMatch
+		r := &match.Result
+		if err := rows.Scan(&match.ID, &match.SubscriptionID, &r.ChatID, &r.MessageID, &r.SenderID, &r.SenderName, &r.Username,
+			&r.ChatType, &r.Headline, &r.SentDate); err != nil {
+			return nil, err
+		}
+		r.Text = r.Headline
+		matches = append(matches, match)
+	}
+	return matches, rows.Err()
+}
+
+// ClearSubscriptionMatches forgets the matches of a subscription up to throughID once its digest was sent
+func (db *DB) ClearSubscriptionMatches(subscriptionID int64, throughID int64, sent time.Time) error {
+	if _, err := db.Exec("DELETE FROM subscription_matches WHERE subscription_id = $1 AND id <= $2", subscriptionID, throughID); err != nil {
+		return err
+	}
+	_, err := db.Exec("UPDATE subscriptions SET last_digest = $2 WHERE id = $1", subscriptionID, sent)
+	return err
+}
+
+// DueDigests returns the subscriptions with matches waiting whose last digest was sent before the given time
+func (db *DB) DueDigests(before time.Time) ([]Subscription, error) {
+	query := "SELECT " + subscriptionColumns + " FROM subscriptions s WHERE last_digest <= $1 " +
+		"AND EXISTS (SELECT 1 FROM subscription_matches m WHERE m.subscription_id = s.id) ORDER BY id"
+	return db.querySubscriptions(query, before)
+}
@@ -0,0 +1,143 @@
+package structs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// DocumentRules decides which documents may be posted in a chat
+type DocumentRules struct {
+	BlockedMimeTypes  []string `json:"blocked_mime_types"` // e.g. "application/vnd.android.package-archive" or "application/*"
+	BlockedExtensions []string `json:"blocked_extensions"` // e.g. ".apk"
+	MaxSize           int      `json:"max_size"`           // in bytes, 0 means no limit
+	ScanHashes        bool     `json:"scan_hashes"`        // compare the SHA-256 of documents against the bad file list
+}
+
+// Check returns the reason a document breaks the rules, or an empty string if it passes.
+// Only the metadata Telegram sends with the message is used, nothing is downloaded.
+func (r DocumentRules) Check(document *tgbotapi.Document) string {
+	extension := strings.ToLower(filepath.Ext(document.FileName))
+	for _, blocked := range r.BlockedExtensions {
+		if extension != "" && extension == strings.ToLower(blocked) {
+			return fmt.Sprintf("%s files are not allowed in this chat.", extension)
+		}
+	}
+
+	mimeType := strings.ToLower(document.MimeType)
+	for _, blocked := range r.BlockedMimeTypes {
+		blocked = strings.ToLower(blocked)
+		if mimeType == blocked || (strings.HasSuffix(blocked, "/*") && strings.HasPrefix(mimeType, strings.TrimSuffix(blocked, "*"))) {
+			return fmt.Sprintf("%s files are not allowed in this chat.", document.MimeType)
+		}
+	}
+
+	if r.MaxSize > 0 && document.FileSize > r.MaxSize {
+		return fmt.Sprintf("Files larger than %d KB are not allowed in this chat.", r.MaxSize/1024)
+	}
+	return ""
+}
+
+// documentHash returns the SHA-256 of a document, downloading it only if it was never seen before
+func (b *TeleBot) documentHash(document *tgbotapi.Document) (string, error) {
+	hash, err := b.DB.DocumentHash(document.FileUniqueID)
+	if err != nil {
+		return "", err
+	}
+	if hash != "" {
+		return hash, nil
+	}
+
+	data, err := b.DownloadFile(document.FileID)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	hash = hex.EncodeToString(sum[:])
+
+	if err := b.DB.StoreDocumentHash(document.FileUniqueID, hash); err != nil {
+		log.Println("Error storing document hash:", err)
+	}
+	return hash, nil
+}
+
+// CheckDocument removes the message if its document breaks the chat's document rules.
+// It returns true when the message was removed.
+func (b *TeleBot) CheckDocument(update tgbotapi.Update) bool {
+	message := update.Message
+	if message.Document == nil {
+		return false
+	}
+	rules := b.ChatSettings(message.Chat.ID).Documents
+
+	if reason := rules.Check(message.Document); reason != "" {
+		b.Moderate(message, RuleDocument, reason)
+		return true
+	}
+
+	// Files the bot cannot download are never hashed
+	if !rules.ScanHashes || message.Document.FileSize > MaxDownloadSize {
+		return false
+	}
+
+	hasBadFiles, err := b.DB.HasBadFiles(message.Chat.ID)
+	if err != nil {
+		log.Println("Error checking bad file list:", err)
+		return false
+	}
+	if !hasBadFiles {
+		return false
+	}
+
+	hash, err := b.documentHash(message.Document)
+	if err != nil {
+		log.Println("Error hashing document:", err)
+		return false
+	}
+
+	bad, err := b.DB.IsBadFile(message.Chat.ID, hash)
+	if err != nil {
+		log.Println("Error checking bad file list:", err)
+		return false
+	}
+	if bad {
+		b.Moderate(message, RuleDocument, "This file is known to be malicious.")
+		return true
+	}
+	return false
+}
+
+// BanFile adds the document of the replied message to the chat's bad file list
+func (b *TeleBot) BanFile(update tgbotapi.Update) {
+	message := update.Message
+
+	target := message.ReplyToMessage
+	if target == nil || target.Document == nil {
+		b.reply(message, "Reply to a file with /banfile to ban it.")
+		return
+	}
+	if target.Document.FileSize > MaxDownloadSize {
+		b.reply(message, "This file is too large for the bot to download.")
+		return
+	}
+
+	hash, err := b.documentHash(target.Document)
+	if err != nil {
+		log.Println("Error hashing document:", err)
+		b.reply(message, "Could not download the file. Please try again.")
+		return
+	}
+
+	if err := b.DB.AddBadFile(message.Chat.ID, hash, message.From.ID); err != nil {
+		b.reply(message, "Could not save the banned file. Please try again.")
+		return
+	}
+
+	b.RemoveMessage(target, "")
+	b.reply(message, "File banned. Reposts of it will be removed.")
+}
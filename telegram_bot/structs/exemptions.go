@@ -0,0 +1,173 @@
+package structs
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// adminCacheTTL is how long the administrator list of a chat is trusted before it is fetched again
+const adminCacheTTL = 10 * time.Minute
+
+// Exemptions lists the users of a chat whose messages are not filtered
+type Exemptions struct {
+	TrustedUsers  []int64 `json:"trusted_users"`
+	ExemptAdmins  bool    `json:"exempt_admins"`   // chat creator and administrators
+	MinMemberDays int     `json:"min_member_days"` // members known for more than this many days, 0 means off
+}
+
+// IsTrusted reports whether the user is on the trusted list
+func (e Exemptions) IsTrusted(userID int64) bool {
+	for _, trusted := range e.TrustedUsers {
+		if trusted == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// adminCache keeps the administrators of each chat, as returned by getChatAdministrators
+type adminCache struct {
+	mu    sync.Mutex
+	chats map[int64]cachedAdmins
+}
+
+type cachedAdmins struct {
+	ids     map[int64]bool
+	fetched time.Time
+}
+
+// chatAdmins returns the IDs of the creator and administrators of a chat, fetching them at most every adminCacheTTL
+func (b *TeleBot) chatAdmins(chatID int64) (map[int64]bool, error) {
+	b.admins.mu.Lock()
+	cached, ok := b.admins.chats[chatID]
+	b.admins.mu.Unlock()
+	if ok && time.Since(cached.fetched) < adminCacheTTL {
+		return cached.ids, nil
+	}
+
+	// The lock is not held during the request, so checks in other chats do not wait for Telegram
+	members, err := b.API.GetChatAdministrators(tgbotapi.ChatAdministratorsConfig{ChatConfig: tgbotapi.ChatConfig{ChatID: chatID}})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[int64]bool)
+	for _, member := range members {
+		if member.User != nil {
+			ids[member.User.ID] = true
+		}
+	}
+	b.admins.mu.Lock()
+	defer b.admins.mu.Unlock()
+	if b.admins.chats == nil {
+		b.admins.chats = make(map[int64]cachedAdmins)
+	}
+	b.admins.chats[chatID] = cachedAdmins{ids: ids, fetched: time.Now()}
+	return ids, nil
+}
+
+// IsExempt reports whether the sender of a message is exempt from the chat's filters.
+// Exemptions only apply to groups, in a private chat the user is always filtered.
+func (b *TeleBot) IsExempt(message *tgbotapi.Message) bool {
+	if message.From == nil || message.Chat.Type == ChatPrivate {
+		return false
+	}
+	exemptions := b.ChatSettings(message.Chat.ID).Exemptions
+
+	if exemptions.IsTrusted(message.From.ID) {
+		return true
+	}
+
+	if exemptions.ExemptAdmins {
+		admins, err := b.chatAdmins(message.Chat.ID)
+		if err != nil {
+			log.Println("Error fetching chat administrators:", err)
+		} else if admins[message.From.ID] {
+			return true
+		}
+	}
+
+	if exemptions.MinMemberDays > 0 {
+		firstSeen, err := b.DB.TouchMember(message.Chat.ID, message.From.ID, time.Now())
+		if err != nil {
+			log.Println("Error storing chat member:", err)
+		} else if time.Since(firstSeen) > time.Duration(exemptions.MinMemberDays)*24*time.Hour {
+			return true
+		}
+	}
+	return false
+}
+
+// Trust adds a user to the chat's trusted list, or shows the exemptions without arguments
+func (b *TeleBot) Trust(update tgbotapi.Update) {
+	b.editTrusted(update, true)
+}
+
+// Untrust removes a user from the chat's trusted list
+func (b *TeleBot) Untrust(update tgbotapi.Update) {
+	b.editTrusted(update, false)
+}
+
+// editTrusted implements /trust and /untrust. The user is the author of the replied message or a numeric ID argument.
+func (b *TeleBot) editTrusted(update tgbotapi.Update, trust bool) {
+	message := update.Message
+
+	settings := b.ChatSettings(message.Chat.ID)
+	exemptions := settings.Exemptions
+	args := strings.Fields(message.CommandArguments())
+
+	var userID int64
+	switch {
+	case message.ReplyToMessage != nil && message.ReplyToMessage.From != nil:
+		userID = message.ReplyToMessage.From.ID
+	case trust && len(args) == 2 && args[0] == "days":
+		days, err := strconv.Atoi(args[1])
+		if err != nil || days < 0 {
+			b.reply(message, "Please provide a positive number of days.")
+			return
+		}
+		exemptions.MinMemberDays = days
+	case len(args) == 1:
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			b.reply(message, "Please provide a numeric user ID or reply to a message of the user.")
+			return
+		}
+		userID = id
+	case trust && len(args) == 0:
+		reply := fmt.Sprintf("Trusted users: %v\nAdmins exempt: %t\nMembers exempt after: %d days (0 means off)\n\n"+
+			"Usage: reply to a message with /trust or /untrust, or use /trust <user ID>, /untrust <user ID>, /trust days <number>",
+			exemptions.TrustedUsers, exemptions.ExemptAdmins, exemptions.MinMemberDays)
+		b.reply(message, reply)
+		return
+	default:
+		b.reply(message, "Reply to a message of the user, or provide their numeric user ID.")
+		return
+	}
+
+	if userID != 0 {
+		var users []int64
+		for _, id := range exemptions.TrustedUsers {
+			if id != userID {
+				users = append(users, id)
+			}
+		}
+		if trust {
+			users = append(users, userID)
+		}
+		exemptions.TrustedUsers = users
+	}
+
+	settings.Exemptions = exemptions
+	if err := b.SaveChatSettings(message.Chat.ID, settings); err != nil {
+		b.reply(message, "Could not save the trusted users. Please try again.")
+		return
+	}
+	b.reply(message, "Trusted users updated.")
+}
@@ -0,0 +1,127 @@
+package structs
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeAPI is a Bot API server for tests. It answers every method, records the calls
+// and serves the files registered with addFile.
+type fakeAPI struct {
+	server *httptest.Server
+
+	mu      sync.Mutex
+	calls   []fakeCall
+	results map[string]string // result JSON by method, for the methods that need more than true
+	errors  map[string]int    // error code by method, for the methods that fail
+	files   map[string][]byte // by file ID
+}
+
+// fakeCall is a recorded Bot API request
+type fakeCall struct {
+	Method string
+	Params map[string]string
+}
+
+// newFakeBot returns a bot talking to a fake Bot API server and keeping its data in memory
+func newFakeBot(t *testing.T) (*TeleBot, *fakeAPI) {
+	t.Helper()
+
+	api := &fakeAPI{
+		results: map[string]string{
+			"getMe":       `{"id":1,"is_bot":true,"first_name":"Test","username":"test_bot"}`,
+			"sendMessage": `{"message_id":1,"date":0,"chat":{"id":0}}`,
+		},
+		errors: make(map[string]int),
+		files:  make(map[string][]byte),
+	}
+	api.server = httptest.NewServer(http.HandlerFunc(api.serve))
+	t.Cleanup(api.server.Close)
+
+	bot, err := NewBotWithEndpoints("123:token", api.server.URL+"/bot%s/%s", api.server.URL+"/file/bot%s/%s", NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bot, api
+}
+
+// serve answers a Bot API request or a file download
+func (api *fakeAPI) serve(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	if parts[0] == "file" {
+		api.mu.Lock()
+		data, ok := api.files[parts[len(parts)-1]]
+		api.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+		return
+	}
+
+	method := parts[len(parts)-1]
+	r.ParseForm()
+	call := fakeCall{Method: method, Params: make(map[string]string)}
+	for key := range r.PostForm {
+		call.Params[key] = r.PostForm.Get(key)
+	}
+
+	api.mu.Lock()
+	api.calls = append(api.calls, call)
+	result, ok := api.results[method]
+	code, failed := api.errors[method]
+	api.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if failed {
+		w.Write([]byte(fmt.Sprintf(`{"ok":false,"error_code":%d,"description":"%s failed"}`, code, method)))
+		return
+	}
+
+	if method == "getFile" {
+		fileID := call.Params["file_id"]
+		result = `{"file_id":"` + fileID + `","file_path":"files/` + fileID + `"}`
+	} else if !ok {
+		result = "true"
+	}
+	w.Write([]byte(`{"ok":true,"result":` + result + `}`))
+}
+
+// setResult sets the result JSON of a method, or makes it fail with an error code if code is not 0
+func (api *fakeAPI) setResult(method string, result string, code int) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if code != 0 {
+		api.errors[method] = code
+		return
+	}
+	delete(api.errors, method)
+	api.results[method] = result
+}
+
+// addFile registers the content of a file
+func (api *fakeAPI) addFile(fileID string, data []byte) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.files[fileID] = data
+}
+
+// called returns the recorded calls of a method
+func (api *fakeAPI) called(method string) []fakeCall {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	var calls []fakeCall
+	for _, call := range api.calls {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
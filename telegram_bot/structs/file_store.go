@@ -0,0 +1,707 @@
+package structs
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// compactAfter is the number of records appended to the storage file before it is compacted into a snapshot
+const compactAfter = 10000
+
+// FileStore keeps everything in memory and persists it to a single append-only file, for deployments without Postgres.
+// Every change is appended to the file as a JSON record. On start the file is replayed, and it is regularly
+// rewritten as a single snapshot of the current state so it does not grow forever.
+type FileStore struct {
+	*MemoryStore // serves every read
+
+	mu      sync.Mutex // serializes writes, so the file and memory see changes in the same order
+	path    string
+	file    *os.File
+	pending int // records appended since the last compaction
+}
+
+// fileRecord is one line of the storage file: a change, or a snapshot of the whole state
+type fileRecord struct {
+	Op string `json:"op"`
+
+	ChatID    int64     `json:"chat_id,omitempty"`
+	UserID    int64     `json:"user_id,omitempty"`
+	ID        int64     `json:"id,omitempty"`
+	Key       string    `json:"key,omitempty"`
+	Hash      string    `json:"hash,omitempty"`
+	ImageHash uint64    `json:"image_hash,omitempty"`
+	Status    string    `json:"status,omitempty"`
+	Text      string    `json:"text,omitempty"`
+	Role      Role      `json:"role,omitempty"`
+	Digest    bool      `json:"digest,omitempty"`
+	Time      time.Time `json:"time,omitempty"`
+
+	Message      *StoredMessage     `json:"message,omitempty"`
+	ReviewItem   *ReviewItem        `json:"review_item,omitempty"`
+	Event        *ModerationEvent   `json:"event,omitempty"`
+	Appeal       *Appeal            `json:"appeal,omitempty"`
+	Challenge    *CaptchaChallenge  `json:"challenge,omitempty"`
+	Lockdown     *Lockdown          `json:"lockdown,omitempty"`
+	Connection   *Connection        `json:"connection,omitempty"`
+	Settings     *ChatSettings      `json:"settings,omitempty"`
+	Subscription *Subscription      `json:"subscription,omitempty"`
+	Match        *SubscriptionMatch `json:"match,omitempty"`
+	Snapshot     *memorySnapshot    `json:"snapshot,omitempty"`
+}
+
+// Operations recorded in the storage file
+const (
+	opSnapshot               = "snapshot"
+	opStoreMessage           = "store_message"
+	opPurgeMessages          = "purge_messages"
+	opAddBannedImage         = "add_banned_image"
+	opStoreDocumentHash      = "store_document_hash"
+	opAddBadFile             = "add_bad_file"
+	opCreateReviewItem       = "create_review_item"
+	opDecideReviewItem       = "decide_review_item"
+	opAddStrike              = "add_strike"
+	opResetStrikes           = "reset_strikes"
+	opRecordModeration       = "record_moderation"
+	opCreateAppeal           = "create_appeal"
+	opDecideAppeal           = "decide_appeal"
+	opAddAppealMessage       = "add_appeal_message"
+	opSaveCaptchaChallenge   = "save_captcha_challenge"
+	opDeleteCaptchaChallenge = "delete_captcha_challenge"
+	opSaveLockdown           = "save_lockdown"
+	opDeleteLockdown         = "delete_lockdown"
+	opTouchMember            = "touch_member"
+	opForgetMember           = "forget_member"
+	opSetRole                = "set_role"
+	opDeleteRole             = "delete_role"
+	opSaveConnection         = "save_connection"
+	opSetActiveConnection    = "set_active_connection"
+	opDeleteConnection       = "delete_connection"
+	opSaveChatSettings       = "save_chat_settings"
+	opCreateSubscription     = "create_subscription"
+	opSetSubscriptionDigest  = "set_subscription_digest"
+	opDeleteSubscription     = "delete_subscription"
+	opAddSubscriptionMatch   = "add_subscription_match"
+	opClearSubscription      = "clear_subscription_matches"
+)
+
+// NewFileStore opens the storage file at path, creating it if needed, and loads its content
+func NewFileStore(path string) (*FileStore, error) {
+	f := &FileStore{MemoryStore: NewMemoryStore(), path: path}
+
+	replayed, err := f.replay()
+	if err != nil {
+		return nil, err
+	}
+
+	f.file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start from a single snapshot, so the file only holds the changes of the current run
+	if replayed > 1 {
+		if err := f.compact(); err != nil {
+			f.file.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// replay applies the records of the storage file and returns how many there were.
+// A record cut short by a crash while it was written is dropped.
+func (f *FileStore) replay() (int, error) {
+	file, err := os.Open(f.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	count := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("Dropping an incomplete record at the end of %s", f.path)
+				return count, os.Truncate(f.path, offset)
+			}
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+
+		var record fileRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return count, fmt.Errorf("storage file %s is corrupt at offset %d: %w", f.path, offset, err)
+		}
+		if _, err := f.apply(record); err != nil {
+			return count, fmt.Errorf("storage file %s: replaying %s at offset %d: %w", f.path, record.Op, offset, err)
+		}
+		offset += int64(len(line))
+		count++
+	}
+}
+
+// record appends a change to the file, then applies it in memory.
+// It returns the ID or number of strikes produced by the change, if any.
+func (f *FileStore) record(record fileRecord) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, errors.New("storage file is closed")
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return 0, err
+	}
+	info, err := f.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	// A record partly written, or one that cannot be applied, is cut off again:
+	// the records after it would be appended to a broken line and the file could not be replayed
+	if _, err := f.file.Write(append(data, '\n')); err != nil {
+		f.truncate(info.Size())
+		return 0, err
+	}
+	result, err := f.apply(record)
+	if err != nil {
+		f.truncate(info.Size())
+		return 0, err
+	}
+
+	f.pending++
+	if f.pending >= compactAfter {
+		if err := f.compact(); err != nil {
+			log.Println("Error compacting storage file:", err)
+		}
+	}
+	return result, nil
+}
+
+// truncate cuts the file back to the size it had before a failed record, the caller holds the lock
+func (f *FileStore) truncate(size int64) {
+	if err := f.file.Truncate(size); err != nil {
+		log.Println("Error truncating storage file:", err)
+	}
+}
+
+// apply makes a recorded change in memory
+func (f *FileStore) apply(record fileRecord) (int64, error) {
+	m := f.MemoryStore
+
+	switch record.Op {
+	case opSnapshot:
+		if record.Snapshot == nil {
+			return 0, errors.New("empty snapshot")
+		}
+		m.restore(*record.Snapshot)
+		return 0, nil
+	case opStoreMessage:
+		return 0, m.StoreMessage(*record.Message)
+	case opPurgeMessages:
+		return 0, m.PurgeMessages(record.ChatID, record.Time)
+	case opAddBannedImage:
+		return 0, m.AddBannedImage(record.ChatID, record.ImageHash, record.UserID)
+	case opStoreDocumentHash:
+		return 0, m.StoreDocumentHash(record.Key, record.Hash)
+	case opAddBadFile:
+		return 0, m.AddBadFile(record.ChatID, record.Hash, record.UserID)
+	case opCreateReviewItem:
+		return m.CreateReviewItem(*record.ReviewItem)
+	case opDecideReviewItem:
+		return 0, m.DecideReviewItem(record.ID, record.Status, record.UserID)
+	case opAddStrike:
+		strikes, err := m.AddStrike(record.ChatID, record.UserID)
+		return int64(strikes), err
+	case opResetStrikes:
+		return 0, m.ResetStrikes(record.ChatID, record.UserID)
+	case opRecordModeration:
+		return m.RecordModeration(*record.Event)
+	case opCreateAppeal:
+		return m.CreateAppeal(*record.Appeal)
+	case opDecideAppeal:
+		return 0, m.DecideAppeal(record.ID, record.Status, record.UserID)
+	case opAddAppealMessage:
+		return 0, m.AddAppealMessage(record.ID, record.UserID, record.Text, record.Time)
+	case opSaveCaptchaChallenge:
+		return 0, m.SaveCaptchaChallenge(*record.Challenge)
+	case opDeleteCaptchaChallenge:
+		return 0, m.DeleteCaptchaChallenge(record.ChatID, record.UserID)
+	case opSaveLockdown:
+		return 0, m.SaveLockdown(*record.Lockdown)
+	case opDeleteLockdown:
+		return 0, m.DeleteLockdown(record.ChatID)
+	case opTouchMember:
+		_, err := m.TouchMember(record.ChatID, record.UserID, record.Time)
+		return 0, err
+	case opForgetMember:
+		return 0, m.ForgetMember(record.ChatID, record.UserID)
+	case opSetRole:
+		return 0, m.SetRole(record.ChatID, record.UserID, record.Role)
+	case opDeleteRole:
+		return 0, m.DeleteRole(record.ChatID, record.UserID)
+	case opSaveConnection:
+		return 0, m.SaveConnection(*record.Connection)
+	case opSetActiveConnection:
+		return 0, m.SetActiveConnection(record.UserID, record.ChatID)
+	case opDeleteConnection:
+		return 0, m.DeleteConnection(record.UserID, record.ChatID)
+	case opSaveChatSettings:
+		return 0, m.SaveChatSettings(record.ChatID, *record.Settings)
+	case opCreateSubscription:
+		return m.CreateSubscription(*record.Subscription)
+	case opSetSubscriptionDigest:
+		return 0, m.SetSubscriptionDigest(record.ID, record.Digest)
+	case opDeleteSubscription:
+		return 0, m.DeleteSubscription(record.ID)
+	case opAddSubscriptionMatch:
+		return 0, m.AddSubscriptionMatch(*record.Match)
+	case opClearSubscription:
+		return 0, m.ClearSubscriptionMatches(record.ID, record.Match.ID, record.Time)
+	}
+	return 0, fmt.Errorf("unknown operation %q", record.Op)
+}
+
+// compact replaces the file with a snapshot of the current state, the caller holds the lock
+func (f *FileStore) compact() error {
+	data, err := json.Marshal(fileRecord{Op: opSnapshot, Snapshot: f.MemoryStore.snapshot()})
+	if err != nil {
+		return err
+	}
+
+	// Write the snapshot next to the file and swap them, so a crash leaves one of the two intact
+	tmpPath := f.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, f.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	f.file.Close()
+	f.file = file
+	f.pending = 0
+	return nil
+}
+
+// Close flushes the storage file to disk and closes it
+func (f *FileStore) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Sync()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	f.file = nil
+	return err
+}
+
+// StoreMessage stores a message together with its matches, replacing a stored message with the same ID
+func (f *FileStore) StoreMessage(message StoredMessage) error {
+	_, err := f.record(fileRecord{Op: opStoreMessage, Message: &message})
+	return err
+}
+
+// PurgeMessages deletes the stored messages of a chat sent before the given time
+func (f *FileStore) PurgeMessages(chatID int64, before time.Time) error {
+	_, err := f.record(fileRecord{Op: opPurgeMessages, ChatID: chatID, Time: before})
+	return err
+}
+
+// AddBannedImage adds an image hash to a chat's image blocklist
+func (f *FileStore) AddBannedImage(chatID int64, hash uint64, addedBy int64) error {
+	_, err := f.record(fileRecord{Op: opAddBannedImage, ChatID: chatID, ImageHash: hash, UserID: addedBy})
+	return err
+}
+
+// StoreDocumentHash remembers the SHA-256 of a file
+func (f *FileStore) StoreDocumentHash(fileUniqueID string, hash string) error {
+	_, err := f.record(fileRecord{Op: opStoreDocumentHash, Key: fileUniqueID, Hash: hash})
+	return err
+}
+
+// AddBadFile adds a SHA-256 to a chat's bad file list, chat 0 is the global list
+func (f *FileStore) AddBadFile(chatID int64, hash string, addedBy int64) error {
+	_, err := f.record(fileRecord{Op: opAddBadFile, ChatID: chatID, Hash: hash, UserID: addedBy})
+	return err
+}
+
+// CreateReviewItem stores a message sent to the review queue and returns its ID
+func (f *FileStore) CreateReviewItem(item ReviewItem) (int64, error) {
+	return f.record(fileRecord{Op: opCreateReviewItem, ReviewItem: &item})
+}
+
+// DecideReviewItem records the decision taken on a review item
+func (f *FileStore) DecideReviewItem(id int64, status string, decidedBy int64) error {
+	_, err := f.record(fileRecord{Op: opDecideReviewItem, ID: id, Status: status, UserID: decidedBy})
+	return err
+}
+
+// AddStrike adds a strike to a user in a chat and returns their number of strikes
+func (f *FileStore) AddStrike(chatID int64, userID int64) (int, error) {
+	strikes, err := f.record(fileRecord{Op: opAddStrike, ChatID: chatID, UserID: userID})
+	return int(strikes), err
+}
+
+// ResetStrikes clears the strikes of a user in a chat
+func (f *FileStore) ResetStrikes(chatID int64, userID int64) error {
+	_, err := f.record(fileRecord{Op: opResetStrikes, ChatID: chatID, UserID: userID})
+	return err
+}
+
+// RecordModeration stores a moderation event and returns its ID
+func (f *FileStore) RecordModeration(event ModerationEvent) (int64, error) {
+	return f.record(fileRecord{Op: opRecordModeration, Event: &event})
+}
+
+// CreateAppeal stores a new appeal and returns its ID
+func (f *FileStore) CreateAppeal(appeal Appeal) (int64, error) {
+	return f.record(fileRecord{Op: opCreateAppeal, Appeal: &appeal})
+}
+
+// DecideAppeal records the decision taken on an appeal
+func (f *FileStore) DecideAppeal(id int64, status string, decidedBy int64) error {
+	_, err := f.record(fileRecord{Op: opDecideAppeal, ID: id, Status: status, UserID: decidedBy})
+	return err
+}
+
+// AddAppealMessage adds a message to the thread of an appeal
+func (f *FileStore) AddAppealMessage(appealID int64, senderID int64, text string, sentDate time.Time) error {
+	_, err := f.record(fileRecord{Op: opAddAppealMessage, ID: appealID, UserID: senderID, Text: text, Time: sentDate})
+	return err
+}
+
+// SaveCaptchaChallenge creates or updates the pending challenge of a new member
+func (f *FileStore) SaveCaptchaChallenge(challenge CaptchaChallenge) error {
+	_, err := f.record(fileRecord{Op: opSaveCaptchaChallenge, Challenge: &challenge})
+	return err
+}
+
+// DeleteCaptchaChallenge removes the challenge of a member
+func (f *FileStore) DeleteCaptchaChallenge(chatID int64, userID int64) error {
+	_, err := f.record(fileRecord{Op: opDeleteCaptchaChallenge, ChatID: chatID, UserID: userID})
+	return err
+}
+
+// SaveLockdown stores a chat lockdown
+func (f *FileStore) SaveLockdown(lockdown Lockdown) error {
+	_, err := f.record(fileRecord{Op: opSaveLockdown, Lockdown: &lockdown})
+	return err
+}
+
+// DeleteLockdown removes the lockdown of a chat
+func (f *FileStore) DeleteLockdown(chatID int64) error {
+	_, err := f.record(fileRecord{Op: opDeleteLockdown, ChatID: chatID})
+	return err
+}
+
+// TouchMember records the first time a user was seen in a chat and returns it
+func (f *FileStore) TouchMember(chatID int64, userID int64, seen time.Time) (time.Time, error) {
+	// Members are touched on every message, only the first sighting is worth a record
+	if firstSeen, ok := f.MemoryStore.firstSeen(chatID, userID); ok {
+		return firstSeen, nil
+	}
+	if _, err := f.record(fileRecord{Op: opTouchMember, ChatID: chatID, UserID: userID, Time: seen}); err != nil {
+		return seen, err
+	}
+	firstSeen, _ := f.MemoryStore.firstSeen(chatID, userID)
+	return firstSeen, nil
+}
+
+// ForgetMember deletes what is known about a user in a chat they left
+func (f *FileStore) ForgetMember(chatID int64, userID int64) error {
+	_, err := f.record(fileRecord{Op: opForgetMember, ChatID: chatID, UserID: userID})
+	return err
+}
+
+// SetRole stores the role of a user in a chat
+func (f *FileStore) SetRole(chatID int64, userID int64, role Role) error {
+	_, err := f.record(fileRecord{Op: opSetRole, ChatID: chatID, UserID: userID, Role: role})
+	return err
+}
+
+// DeleteRole removes the stored role of a user in a chat
+func (f *FileStore) DeleteRole(chatID int64, userID int64) error {
+	_, err := f.record(fileRecord{Op: opDeleteRole, ChatID: chatID, UserID: userID})
+	return err
+}
+
+// SaveConnection links the private chat of a user to a group and makes it the active one
+func (f *FileStore) SaveConnection(connection Connection) error {
+	_, err := f.record(fileRecord{Op: opSaveConnection, Connection: &connection})
+	return err
+}
+
+// SetActiveConnection makes one of the connected groups of a user the one their commands apply to
+func (f *FileStore) SetActiveConnection(userID int64, chatID int64) error {
+	_, err := f.record(fileRecord{Op: opSetActiveConnection, UserID: userID, ChatID: chatID})
+	return err
+}
+
+// DeleteConnection unlinks the private chat of a user from a group
+func (f *FileStore) DeleteConnection(userID int64, chatID int64) error {
+	_, err := f.record(fileRecord{Op: opDeleteConnection, UserID: userID, ChatID: chatID})
+	return err
+}
+
+// SaveChatSettings creates or replaces the settings of a chat
+func (f *FileStore) SaveChatSettings(chatID int64, settings ChatSettings) error {
+	_, err := f.record(fileRecord{Op: opSaveChatSettings, ChatID: chatID, Settings: &settings})
+	return err
+}
+
+// CreateSubscription stores a search a user subscribed to and returns its ID
+func (f *FileStore) CreateSubscription(subscription Subscription) (int64, error) {
+	return f.record(fileRecord{Op: opCreateSubscription, Subscription: &subscription})
+}
+
+// SetSubscriptionDigest switches a subscription between immediate alerts and a digest
+func (f *FileStore) SetSubscriptionDigest(id int64, digest bool) error {
+	_, err := f.record(fileRecord{Op: opSetSubscriptionDigest, ID: id, Digest: digest})
+	return err
+}
+
+// DeleteSubscription removes a subscription and the matches waiting for its digest
+func (f *FileStore) DeleteSubscription(id int64) error {
+	_, err := f.record(fileRecord{Op: opDeleteSubscription, ID: id})
+	return err
+}
+
+// AddSubscriptionMatch keeps a message found by a subscription until its digest is sent
+func (f *FileStore) AddSubscriptionMatch(match SubscriptionMatch) error {
+	_, err := f.record(fileRecord{Op: opAddSubscriptionMatch, Match: &match})
+	return err
+}
+
+// ClearSubscriptionMatches forgets the matches of a subscription up to throughID once its digest was sent
+func (f *FileStore) ClearSubscriptionMatches(subscriptionID int64, throughID int64, sent time.Time) error {
+	_, err := f.record(fileRecord{Op: opClearSubscription, ID: subscriptionID, Match: &SubscriptionMatch{ID: throughID}, Time: sent})
+	return err
+}
+
+// memorySnapshot is the whole content of a MemoryStore in a form that can be written as JSON
+type memorySnapshot struct {
+	LastID int64 `json:"last_id"`
+
+	Messages []StoredMessage `json:"messages"`
+
+	BannedImages   map[int64][]uint64 `json:"banned_images"`
+	DocumentHashes map[string]string  `json:"document_hashes"`
+	BadFiles       map[int64][]string `json:"bad_files"`
+
+	ReviewItems    []ReviewItem       `json:"review_items"`
+	Strikes        []memberEntry      `json:"strikes"`
+	Events         []ModerationEvent  `json:"events"`
+	Appeals        []Appeal           `json:"appeals"`
+	AppealMessages map[int64][]string `json:"appeal_messages"`
+
+	Captchas  []CaptchaChallenge `json:"captchas"`
+	Lockdowns []Lockdown         `json:"lockdowns"`
+
+	Members     []memberEntry `json:"members"`
+	Roles       []memberEntry `json:"roles"`
+	Connections []Connection  `json:"connections"`
+
+	Settings map[int64]json.RawMessage `json:"settings"`
+
+	Subscriptions       []Subscription      `json:"subscriptions"`
+	SubscriptionMatches []SubscriptionMatch `json:"subscription_matches"`
+}
+
+// memberEntry is what is kept about a user in a chat, in a snapshot
+type memberEntry struct {
+	ChatID    int64     `json:"chat_id"`
+	UserID    int64     `json:"user_id"`
+	Strikes   int       `json:"strikes,omitempty"`
+	Role      Role      `json:"role,omitempty"`
+	FirstSeen time.Time `json:"first_seen,omitempty"`
+}
+
+// firstSeen returns when a user was first seen in a chat, if they were
+func (s *MemoryStore) firstSeen(chatID int64, userID int64) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen, ok := s.members[memberKey{chatID, userID}]
+	return seen, ok
+}
+
+// snapshot returns the content of the store. It shares slices and maps with the store,
+// so it must be written out before the store changes again.
+func (s *MemoryStore) snapshot() *memorySnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := &memorySnapshot{
+		LastID:         s.lastID,
+		Messages:       s.messages,
+		BannedImages:   s.bannedImages,
+		DocumentHashes: s.documentHashes,
+		BadFiles:       make(map[int64][]string),
+		AppealMessages: s.appealMessages,
+		Settings:       make(map[int64]json.RawMessage),
+	}
+	for chatID, hashes := range s.badFiles {
+		for hash := range hashes {
+			snapshot.BadFiles[chatID] = append(snapshot.BadFiles[chatID], hash)
+		}
+	}
+	for _, item := range s.reviewItems {
+		snapshot.ReviewItems = append(snapshot.ReviewItems, item)
+	}
+	for key, strikes := range s.strikes {
+		snapshot.Strikes = append(snapshot.Strikes, memberEntry{ChatID: key.chatID, UserID: key.userID, Strikes: strikes})
+	}
+	for _, event := range s.events {
+		snapshot.Events = append(snapshot.Events, event)
+	}
+	for _, appeal := range s.appeals {
+		snapshot.Appeals = append(snapshot.Appeals, appeal)
+	}
+	for _, challenge := range s.captchas {
+		snapshot.Captchas = append(snapshot.Captchas, challenge)
+	}
+	for _, lockdown := range s.lockdowns {
+		snapshot.Lockdowns = append(snapshot.Lockdowns, lockdown)
+	}
+	for key, seen := range s.members {
+		snapshot.Members = append(snapshot.Members, memberEntry{ChatID: key.chatID, UserID: key.userID, FirstSeen: seen})
+	}
+	for key, role := range s.roles {
+		snapshot.Roles = append(snapshot.Roles, memberEntry{ChatID: key.chatID, UserID: key.userID, Role: role})
+	}
+	for _, connections := range s.connections {
+		snapshot.Connections = append(snapshot.Connections, connections...)
+	}
+	for chatID, data := range s.settings {
+		snapshot.Settings[chatID] = data
+	}
+	for _, subscription := range s.subscriptions {
+		snapshot.Subscriptions = append(snapshot.Subscriptions, subscription)
+	}
+	for _, matches := range s.subscriptionMatches {
+		snapshot.SubscriptionMatches = append(snapshot.SubscriptionMatches, matches...)
+	}
+	return snapshot
+}
+
+// restore replaces the content of the store with a snapshot
+func (s *MemoryStore) restore(snapshot memorySnapshot) {
+	restored := NewMemoryStore()
+	restored.lastID = snapshot.LastID
+	restored.messages = snapshot.Messages
+	restored.indexMessages()
+	for chatID, hashes := range snapshot.BannedImages {
+		restored.bannedImages[chatID] = hashes
+	}
+	for fileUniqueID, hash := range snapshot.DocumentHashes {
+		restored.documentHashes[fileUniqueID] = hash
+	}
+	for chatID, hashes := range snapshot.BadFiles {
+		restored.badFiles[chatID] = make(map[string]bool)
+		for _, hash := range hashes {
+			restored.badFiles[chatID][hash] = true
+		}
+	}
+	for _, item := range snapshot.ReviewItems {
+		restored.reviewItems[item.ID] = item
+	}
+	for _, entry := range snapshot.Strikes {
+		restored.strikes[memberKey{entry.ChatID, entry.UserID}] = entry.Strikes
+	}
+	for _, event := range snapshot.Events {
+		restored.events[event.ID] = event
+	}
+	for _, appeal := range snapshot.Appeals {
+		restored.appeals[appeal.ID] = appeal
+	}
+	for appealID, texts := range snapshot.AppealMessages {
+		restored.appealMessages[appealID] = texts
+	}
+	for _, challenge := range snapshot.Captchas {
+		restored.captchas[memberKey{challenge.ChatID, challenge.UserID}] = challenge
+	}
+	for _, lockdown := range snapshot.Lockdowns {
+		restored.lockdowns[lockdown.ChatID] = lockdown
+	}
+	for _, entry := range snapshot.Members {
+		restored.members[memberKey{entry.ChatID, entry.UserID}] = entry.FirstSeen
+	}
+	for _, entry := range snapshot.Roles {
+		restored.roles[memberKey{entry.ChatID, entry.UserID}] = entry.Role
+	}
+	for _, connection := range snapshot.Connections {
+		restored.connections[connection.UserID] = append(restored.connections[connection.UserID], connection)
+	}
+	for chatID, data := range snapshot.Settings {
+		restored.settings[chatID] = data
+	}
+	for _, subscription := range snapshot.Subscriptions {
+		restored.subscriptions[subscription.ID] = subscription
+	}
+	// Matches are restored in the order they were added, which their IDs follow
+	sort.Slice(snapshot.SubscriptionMatches, func(i, j int) bool {
+		return snapshot.SubscriptionMatches[i].ID < snapshot.SubscriptionMatches[j].ID
+	})
+	for _, match := range snapshot.SubscriptionMatches {
+		restored.subscriptionMatches[match.SubscriptionID] = append(restored.subscriptionMatches[match.SubscriptionID], match)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID = restored.lastID
+	s.messages = restored.messages
+	s.messageIndex = restored.messageIndex
+	s.bannedImages = restored.bannedImages
+	s.documentHashes = restored.documentHashes
+	s.badFiles = restored.badFiles
+	s.reviewItems = restored.reviewItems
+	s.strikes = restored.strikes
+	s.events = restored.events
+	s.appeals = restored.appeals
+	s.appealMessages = restored.appealMessages
+	s.captchas = restored.captchas
+	s.lockdowns = restored.lockdowns
+	s.members = restored.members
+	s.roles = restored.roles
+	s.connections = restored.connections
+	s.settings = restored.settings
+	s.subscriptions = restored.subscriptions
+	s.subscriptionMatches = restored.subscriptionMatches
+}
@@ -0,0 +1,185 @@
+package structs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fillStore makes a few changes of different kinds
+func fillStore(t *testing.T, store Store) {
+	t.Helper()
+	sent := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i, text := range []string{"hello world", "buy cheap pills", "another message"} {
+		message := StoredMessage{ChatID: -100123, MessageID: i + 1, SenderID: 7, ChatType: ChatSupergroup, ContentType: "text", Text: text, SentDate: sent}
+		if err := store.StoreMessage(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.AddStrike(-100123, 7); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.AddStrike(-100123, 7); err != nil {
+		t.Fatal(err)
+	}
+	settings := DefaultChatSettings()
+	settings.FilterWord = "pills"
+	if err := store.SaveChatSettings(-100123, settings); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.RecordModeration(ModerationEvent{ChatID: -100123, UserID: 7, Rule: RuleFilterWord, Action: ActionMute}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkStore checks that the changes of fillStore are there
+func checkStore(t *testing.T, store Store) {
+	t.Helper()
+	count, err := store.CountMessages([]int64{-100123}, SearchQuery{Terms: []string{"pill"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("messages found = %d, want 1", count)
+	}
+	if strikes, err := store.AddStrike(-100123, 7); err != nil || strikes != 3 {
+		t.Errorf("strikes after one more = %d, %v, want 3", strikes, err)
+	}
+	settings, err := store.GetChatSettings(-100123)
+	if err != nil || settings.FilterWord != "pills" {
+		t.Errorf("filter word = %q, %v, want pills", settings.FilterWord, err)
+	}
+	events, err := store.UserModerations(7, 10)
+	if err != nil || len(events) != 1 || events[0].Action != ActionMute {
+		t.Errorf("moderation events = %+v, %v, want one mute", events, err)
+	}
+}
+
+func TestFileStoreReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.data")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillStore(t, store)
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every change was appended as its own record
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines != 7 {
+		t.Errorf("records = %d, want 7", lines)
+	}
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	checkStore(t, reopened)
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.data")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillStore(t, store)
+	store.Close()
+
+	// Opening the file compacts it into a single snapshot, which replays to the same state
+	compacted, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compacted.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := bytes.Count(data, []byte("\n")); lines != 1 || !bytes.Contains(data, []byte(`"op":"snapshot"`)) {
+		t.Errorf("compacted file has %d records, want one snapshot", lines)
+	}
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	checkStore(t, reopened)
+}
+
+func TestFileStoreDropsIncompleteRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.data")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillStore(t, store)
+	store.Close()
+
+	// A crash while writing leaves a record without its end of line
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"op":"add_strike","chat_id":-100`)
+	file.Close()
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	checkStore(t, reopened)
+}
+
+func TestFileStoreFailedRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.data")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fillStore(t, store)
+
+	// A record that cannot be applied is not left in the file, or it could never be replayed
+	if _, err := store.record(fileRecord{Op: "unknown"}); err == nil {
+		t.Fatal("unknown record applied")
+	}
+	store.Close()
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	checkStore(t, reopened)
+}
+
+func TestMemoryStoreReplacesMessages(t *testing.T) {
+	store := NewMemoryStore()
+	old := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, text := range []string{"first", "second", "third"} {
+		store.StoreMessage(StoredMessage{ChatID: 1, MessageID: i + 1, Text: text, SentDate: old.AddDate(0, 0, i)})
+	}
+
+	// Purging moves the kept messages, edits must still find them
+	if err := store.PurgeMessages(1, old.AddDate(0, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	store.StoreMessage(StoredMessage{ChatID: 1, MessageID: 3, Text: "edited"})
+
+	if len(store.messages) != 2 {
+		t.Fatalf("messages = %d, want 2", len(store.messages))
+	}
+	if store.messages[1].Text != "edited" || store.messages[1].SentDate.IsZero() {
+		t.Errorf("edited message = %+v, want the third one with its new text", store.messages[1])
+	}
+}
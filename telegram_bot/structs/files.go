@@ -0,0 +1,44 @@
+package structs
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// MaxDownloadSize is the largest file the bot will download from Telegram (the Bot API limit is 20 MB)
+const MaxDownloadSize = 20 << 20
+
+// DownloadFile fetches the contents of a file through the Bot API file endpoint
+func (b *TeleBot) DownloadFile(fileID string) ([]byte, error) {
+	file, err := b.API.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(b.FileEndpoint, b.API.Token, file.FilePath), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.API.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading %s: unexpected status %s", file.FilePath, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxDownloadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxDownloadSize {
+		return nil, fmt.Errorf("downloading %s: file is larger than %d bytes", file.FilePath, MaxDownloadSize)
+	}
+	return data, nil
+}
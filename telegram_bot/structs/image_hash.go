@@ -0,0 +1,154 @@
+package structs
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"math/bits"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// ImageHashThreshold is the maximum Hamming distance for two images to be treated as the same picture
+const ImageHashThreshold = 10
+
+// DHash computes a 64-bit difference hash of an image.
+// The image is shrunk to a 9x8 grayscale grid and each bit records whether a pixel is brighter than its right neighbour,
+// so the hash survives re-encoding, resizing and small colour changes.
+func DHash(img image.Image) uint64 {
+	grid := grayGrid(img, 9, 8)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if grid[y][x] > grid[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// HashImage decodes an image (JPEG, PNG or GIF) and returns its difference hash
+func HashImage(data []byte) (uint64, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	return DHash(img), nil
+}
+
+// HammingDistance returns the number of bits that differ between two hashes
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// grayGrid averages the image into a width x height grid of luminance values
+func grayGrid(img image.Image, width, height int) [][]float64 {
+	bounds := img.Bounds()
+	grid := make([][]float64, height)
+
+	for gy := 0; gy < height; gy++ {
+		grid[gy] = make([]float64, width)
+		y0 := bounds.Min.Y + gy*bounds.Dy()/height
+		y1 := bounds.Min.Y + (gy+1)*bounds.Dy()/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+
+		for gx := 0; gx < width; gx++ {
+			x0 := bounds.Min.X + gx*bounds.Dx()/width
+			x1 := bounds.Min.X + (gx+1)*bounds.Dx()/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var sum float64
+			var count int
+			for y := y0; y < y1 && y < bounds.Max.Y; y++ {
+				for x := x0; x < x1 && x < bounds.Max.X; x++ {
+					r, g, b, _ := img.At(x, y).RGBA()
+					// ITU-R BT.601 luma
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+					count++
+				}
+			}
+			if count > 0 {
+				grid[gy][gx] = sum / float64(count)
+			}
+		}
+	}
+	return grid
+}
+
+// hashPhoto downloads the smallest size of a photo and returns its hash
+func (b *TeleBot) hashPhoto(photo []tgbotapi.PhotoSize) (uint64, error) {
+	// Telegram sends the sizes in ascending order, the first one is the thumbnail
+	data, err := b.DownloadFile(photo[0].FileID)
+	if err != nil {
+		return 0, err
+	}
+	return HashImage(data)
+}
+
+// CheckPhoto removes the message if its photo matches the chat's image blocklist.
+// It returns true when the message was removed.
+func (b *TeleBot) CheckPhoto(update tgbotapi.Update) bool {
+	message := update.Message
+	if len(message.Photo) == 0 {
+		return false
+	}
+
+	banned, err := b.DB.BannedImages(message.Chat.ID)
+	if err != nil {
+		log.Println("Error loading banned images:", err)
+		return false
+	}
+	if len(banned) == 0 {
+		return false
+	}
+
+	hash, err := b.hashPhoto(message.Photo)
+	if err != nil {
+		log.Println("Error hashing photo:", err)
+		return false
+	}
+
+	for _, bannedHash := range banned {
+		if HammingDistance(hash, bannedHash) <= ImageHashThreshold {
+			b.Moderate(message, RuleBannedImage, "This image is banned in this chat.")
+			return true
+		}
+	}
+	return false
+}
+
+// BanImage adds the photo of the replied message to the chat's image blocklist
+func (b *TeleBot) BanImage(update tgbotapi.Update) {
+	message := update.Message
+
+	target := message.ReplyToMessage
+	if target == nil || len(target.Photo) == 0 {
+		b.reply(message, "Reply to a photo with /banimage to ban it.")
+		return
+	}
+
+	hash, err := b.hashPhoto(target.Photo)
+	if err != nil {
+		log.Println("Error hashing photo:", err)
+		b.reply(message, "Could not download the photo. Please try again.")
+		return
+	}
+
+	if err := b.DB.AddBannedImage(message.Chat.ID, hash, message.From.ID); err != nil {
+		b.reply(message, "Could not save the banned image. Please try again.")
+		return
+	}
+
+	b.RemoveMessage(target, "")
+	b.reply(message, "Image banned. Reposts of it will be removed.")
+}
@@ -0,0 +1,112 @@
+package structs
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// testImage draws a smooth pattern at any size, so the same picture can be made at several resolutions
+func testImage(width, height int, pattern func(x, y float64) float64) image.Image {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := pattern(float64(x)/float64(width), float64(y)/float64(height))
+			img.SetGray(x, y, color.Gray{Y: uint8(127 + 127*v)})
+		}
+	}
+	return img
+}
+
+func waves(x, y float64) float64 { return math.Sin(9*x) * math.Cos(7*y) }
+
+func rings(x, y float64) float64 { return math.Cos(20 * math.Hypot(x-0.3, y-0.6)) }
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDHashSurvivesResizing(t *testing.T) {
+	small := DHash(testImage(90, 80, waves))
+	large := DHash(testImage(720, 640, waves))
+	if d := HammingDistance(small, large); d > ImageHashThreshold {
+		t.Errorf("distance between sizes of the same image = %d, want at most %d", d, ImageHashThreshold)
+	}
+
+	other := DHash(testImage(90, 80, rings))
+	if d := HammingDistance(small, other); d <= ImageHashThreshold {
+		t.Errorf("distance between different images = %d, want more than %d", d, ImageHashThreshold)
+	}
+}
+
+func TestHashImage(t *testing.T) {
+	img := testImage(90, 80, waves)
+	hash, err := HashImage(encodePNG(t, img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != DHash(img) {
+		t.Errorf("HashImage = %x, want %x", hash, DHash(img))
+	}
+
+	if _, err := HashImage([]byte("not an image")); err == nil {
+		t.Error("HashImage of garbage succeeded")
+	}
+}
+
+func photoMessage(fileID string) *tgbotapi.Message {
+	return &tgbotapi.Message{
+		MessageID: 42,
+		From:      &tgbotapi.User{ID: 7, FirstName: "Ali"},
+		Chat:      &tgbotapi.Chat{ID: -100123, Type: ChatSupergroup, Title: "Group"},
+		Photo:     []tgbotapi.PhotoSize{{FileID: fileID}},
+	}
+}
+
+func TestCheckPhoto(t *testing.T) {
+	bot, api := newFakeBot(t)
+	api.addFile("banned", encodePNG(t, testImage(90, 80, waves)))
+	api.addFile("repost", encodePNG(t, testImage(180, 160, waves)))
+	api.addFile("other", encodePNG(t, testImage(90, 80, rings)))
+
+	// Without banned images nothing is downloaded
+	if bot.CheckPhoto(tgbotapi.Update{Message: photoMessage("repost")}) {
+		t.Fatal("photo removed without banned images")
+	}
+	if calls := api.called("getFile"); len(calls) != 0 {
+		t.Fatalf("photo downloaded without banned images: %v", calls)
+	}
+
+	hash, err := HashImage(api.files["banned"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bot.DB.AddBannedImage(-100123, hash, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if bot.CheckPhoto(tgbotapi.Update{Message: photoMessage("other")}) {
+		t.Error("different photo removed")
+	}
+	if calls := api.called("deleteMessage"); len(calls) != 0 {
+		t.Fatalf("message deleted for a different photo: %v", calls)
+	}
+
+	if !bot.CheckPhoto(tgbotapi.Update{Message: photoMessage("repost")}) {
+		t.Fatal("repost of a banned photo kept")
+	}
+	calls := api.called("deleteMessage")
+	if len(calls) != 1 || calls[0].Params["message_id"] != "42" {
+		t.Errorf("deleteMessage calls = %v, want one for message 42", calls)
+	}
+}
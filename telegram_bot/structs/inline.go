@@ -0,0 +1,240 @@
+package structs
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Limits of inline searches, typed as "@bot query" in any chat
+const (
+	inlineCacheTime  = 30 // seconds Telegram may reuse the answer to the same query of the same user
+	inlinePageSize   = 20 // results per answer, more are loaded as the user scrolls
+	maxInlineChats   = 20 // chats searched, the ones the user joined last
+	memberCacheTTL   = 5 * time.Minute
+	maxMemberLookups = 10   // Bot API calls per cache miss, they hold up every other update
+	maxInlineTextLen = 1000 // characters of a result sent to the chat
+	maxSwitchPMText  = 64   // characters of the button asking to open the private chat
+)
+
+// memberCache keeps the chats each user is confirmed to still be a member of,
+// inline queries arrive with every key typed and must not all ask Telegram
+type memberCache struct {
+	mu    sync.Mutex
+	users map[int64]memberChats
+}
+
+// memberChats are the chats of a user confirmed by Telegram
+type memberChats struct {
+	chats   []int64
+	expires time.Time
+}
+
+// HandleInlineQuery answers "@bot query" with the matching messages of the chats the user is a member of
+func (b *TeleBot) HandleInlineQuery(update tgbotapi.Update) {
+	inline := update.InlineQuery
+	answer := tgbotapi.InlineConfig{
+		InlineQueryID: inline.ID,
+		Results:       []interface{}{},
+		CacheTime:     inlineCacheTime,
+		IsPersonal:    true,
+	}
+
+	text := strings.TrimSpace(inline.Query)
+	if text == "" {
+		b.answerInline(answer)
+		return
+	}
+
+	language := ""
+	if inline.From.LanguageCode == "fa" {
+		language = "fa"
+	}
+	query, err := ParseSearchQuery(text, language)
+	if err == nil && len(query.Matched) > 0 {
+		err = errors.New("Only moderators can search with matched:, use /show in the group.")
+	}
+	if err != nil {
+		// Errors are shown above the results as a button to the private chat
+		answer.SwitchPMText = truncateText(err.Error(), maxSwitchPMText)
+		answer.SwitchPMParameter = "search"
+		b.answerInline(answer)
+		return
+	}
+
+	chats := b.memberChats(inline.From.ID)
+	if len(chats) == 0 {
+		answer.SwitchPMText = "No groups to search yet"
+		answer.SwitchPMParameter = "search"
+		b.answerInline(answer)
+		return
+	}
+
+	// One result more than the page tells whether there is a next page
+	after := parseInlineOffset(inline.Offset)
+	results, err := b.DB.SearchMessages(chats, query, after, inlinePageSize+1)
+	if err != nil {
+		log.Println("Error executing query:", err)
+		// Telegram keeps the spinner going until it gets an answer
+		b.answerInline(answer)
+		return
+	}
+	if len(results) > inlinePageSize {
+		results = results[:inlinePageSize]
+		answer.NextOffset = inlineOffset(results[len(results)-1].Cursor())
+	}
+
+	now := time.Now()
+	unmark := strings.NewReplacer(highlightStart, "", highlightStop, "")
+	for _, result := range results {
+		language := b.ChatSettings(result.ChatID).Language
+		title := senderName(result.StoredMessage) + " · " + relativeDate(result.SentDate, now, language)
+		article := tgbotapi.NewInlineQueryResultArticleHTML(strconv.FormatInt(result.ID, 10), title, formatSearchResult(result, language, now, maxInlineTextLen))
+		article.Description = truncateText(unmark.Replace(result.Headline), 100)
+		answer.Results = append(answer.Results, article)
+	}
+	b.answerInline(answer)
+}
+
+// answerInline sends the answer to an inline query
+func (b *TeleBot) answerInline(answer tgbotapi.InlineConfig) {
+	if _, err := b.API.Request(answer); err != nil {
+		log.Println("Error answering inline query:", err)
+	}
+}
+
+// memberChats returns the chats a user may search inline: the groups they were seen in
+// that Telegram confirms they are still a member of, the ones joined last first.
+// Groups the user left are forgotten. A result missing chats Telegram could not answer for is not cached.
+func (b *TeleBot) memberChats(userID int64) []int64 {
+	b.members.mu.Lock()
+	cached, ok := b.members.users[userID]
+	b.members.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.chats
+	}
+
+	seen, err := b.DB.MemberChats(userID)
+	if err != nil {
+		log.Println("Error loading member chats:", err)
+		return nil
+	}
+	var chats []int64
+	complete := true
+	for i, chatID := range seen {
+		if len(chats) == maxInlineChats || i == maxMemberLookups {
+			break
+		}
+		member, err := b.API.GetChatMember(tgbotapi.GetChatMemberConfig{
+			ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID},
+		})
+		if err != nil && !chatGone(err) {
+			// Throttled or unreachable, the chat is asked again on the next query
+			log.Println("Error fetching chat member:", err)
+			complete = false
+			continue
+		}
+		if err != nil || member.HasLeft() || member.WasKicked() {
+			if err := b.DB.ForgetMember(chatID, userID); err != nil {
+				log.Println("Error deleting chat member:", err)
+			}
+			continue
+		}
+		chats = append(chats, chatID)
+	}
+	if !complete {
+		return chats
+	}
+
+	b.members.mu.Lock()
+	defer b.members.mu.Unlock()
+	if b.members.users == nil {
+		b.members.users = make(map[int64]memberChats)
+	}
+	now := time.Now()
+	for key, entry := range b.members.users {
+		if now.After(entry.expires) {
+			delete(b.members.users, key)
+		}
+	}
+	b.members.users[userID] = memberChats{chats: chats, expires: now.Add(memberCacheTTL)}
+	return chats
+}
+
+// stillMember reports whether a user is still a member of a chat they subscribed to.
+// Only that chat is looked up when it is not among the cached member chats. If Telegram
+// cannot answer, the user is assumed to still be a member, so throttling does not lose alerts.
+func (b *TeleBot) stillMember(userID int64, chatID int64) bool {
+	b.members.mu.Lock()
+	cached, ok := b.members.users[userID]
+	b.members.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) && containsChat(cached.chats, chatID) {
+		return true
+	}
+
+	// The chats members left are forgotten, they need no lookup
+	seen, err := b.DB.MemberChats(userID)
+	if err != nil {
+		log.Println("Error loading member chats:", err)
+		return true
+	}
+	if !containsChat(seen, chatID) {
+		return false
+	}
+
+	member, err := b.API.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: userID},
+	})
+	if err != nil && !chatGone(err) {
+		log.Println("Error fetching chat member:", err)
+		return true
+	}
+	if err != nil || member.HasLeft() || member.WasKicked() {
+		if err := b.DB.ForgetMember(chatID, userID); err != nil {
+			log.Println("Error deleting chat member:", err)
+		}
+		return false
+	}
+	return true
+}
+
+// chatGone reports whether a Bot API error means the bot cannot see the chat any more,
+// because it was removed from it or the chat was deleted
+func chatGone(err error) bool {
+	var apiErr *tgbotapi.Error
+	return errors.As(err, &apiErr) && (apiErr.Code == http.StatusBadRequest || apiErr.Code == http.StatusForbidden)
+}
+
+// inlineOffset writes where the next answer to an inline query starts, as rank:microseconds:id
+func inlineOffset(cursor SearchCursor) string {
+	return strconv.FormatFloat(cursor.Rank, 'g', -1, 64) + ":" +
+		strconv.FormatInt(cursor.SentDate.UnixMicro(), 10) + ":" +
+		strconv.FormatInt(cursor.ID, 10)
+}
+
+// parseInlineOffset reads an offset written by inlineOffset, nil for the first answer or an offset it did not write
+func parseInlineOffset(offset string) *SearchCursor {
+	parts := strings.Split(offset, ":")
+	if len(parts) != 3 {
+		return nil
+	}
+	rank, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return nil
+	}
+	micros, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil
+	}
+	id, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &SearchCursor{Rank: rank, SentDate: time.UnixMicro(micros), ID: id}
+}
@@ -0,0 +1,57 @@
+package structs
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMemberChatsBoundsLookups(t *testing.T) {
+	bot, api := newFakeBot(t)
+	api.setResult("getChatMember", `{"user":{"id":7},"status":"member"}`, 0)
+	for chatID := int64(1); chatID <= 2*maxMemberLookups; chatID++ {
+		bot.DB.TouchMember(-chatID, 7, time.Now())
+	}
+
+	if chats := bot.memberChats(7); len(chats) != maxMemberLookups {
+		t.Errorf("member chats = %d, want %d", len(chats), maxMemberLookups)
+	}
+	if calls := len(api.called("getChatMember")); calls != maxMemberLookups {
+		t.Errorf("getChatMember calls = %d, want %d", calls, maxMemberLookups)
+	}
+
+	// The result is cached
+	bot.memberChats(7)
+	if calls := len(api.called("getChatMember")); calls != maxMemberLookups {
+		t.Errorf("getChatMember calls after a cached lookup = %d, want %d", calls, maxMemberLookups)
+	}
+}
+
+func TestMemberChatsFailedLookups(t *testing.T) {
+	bot, api := newFakeBot(t)
+	bot.DB.TouchMember(-1, 7, time.Now())
+
+	// Throttled lookups are not cached, the chat is asked again
+	api.setResult("getChatMember", "", http.StatusTooManyRequests)
+	if chats := bot.memberChats(7); len(chats) != 0 {
+		t.Errorf("member chats while throttled = %v, want none", chats)
+	}
+	api.setResult("getChatMember", `{"user":{"id":7},"status":"member"}`, 0)
+	if chats := bot.memberChats(7); len(chats) != 1 {
+		t.Errorf("member chats after throttling = %v, want the group", chats)
+	}
+}
+
+func TestMemberChatsForgetsLeftChats(t *testing.T) {
+	bot, api := newFakeBot(t)
+	bot.DB.TouchMember(-1, 7, time.Now())
+	bot.DB.TouchMember(-2, 7, time.Now())
+
+	api.setResult("getChatMember", `{"user":{"id":7},"status":"left"}`, 0)
+	if chats := bot.memberChats(7); len(chats) != 0 {
+		t.Errorf("member chats = %v, want none", chats)
+	}
+	if seen, _ := bot.DB.MemberChats(7); len(seen) != 0 {
+		t.Errorf("chats still known = %v, want none", seen)
+	}
+}
@@ -0,0 +1,106 @@
+package structs
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// JoinScreening configures the checks run on the profile of new members
+type JoinScreening struct {
+	Words  []string `json:"words"`  // words checked only on join, in addition to the filter word
+	Action Action   `json:"action"` // what happens to a new member whose profile matches
+}
+
+// profileText collects the name, username and bio of a user into one text to check
+func (b *TeleBot) profileText(user *tgbotapi.User) string {
+	// Usernames cannot contain spaces, words in them are separated by underscores
+	parts := []string{user.FirstName, user.LastName, strings.ReplaceAll(user.UserName, "_", " ")}
+
+	// The bio is only available through getChat and only if the user allows it
+	chat, err := b.API.GetChat(tgbotapi.ChatInfoConfig{ChatConfig: tgbotapi.ChatConfig{ChatID: user.ID}})
+	if err == nil {
+		parts = append(parts, chat.Bio)
+	}
+	return strings.Join(parts, " ")
+}
+
+// ScreenNewMember checks the profile of a new member against the filter word and the chat's join-only words.
+// It returns true when the member was muted, kicked or banned. With ActionNone the match is only
+// announced and recorded, and the member still gets the captcha.
+func (b *TeleBot) ScreenNewMember(chat *tgbotapi.Chat, user *tgbotapi.User) bool {
+	screening := b.ChatSettings(chat.ID).JoinScreening
+
+	words := screening.Words
+	if filterWord := b.ChatSettings(chat.ID).FilterWord; filterWord != "" {
+		words = append([]string{filterWord}, words...)
+	}
+	if len(words) == 0 {
+		return false
+	}
+
+	profile := b.profileText(user)
+	for _, word := range words {
+		if !ContainsWord(profile, word) {
+			continue
+		}
+
+		if err := b.ApplyAction(chat.ID, user.ID, screening.Action); err != nil {
+			log.Println("Error applying join screening action:", err)
+		}
+		b.RecordModeration(chat, user, RuleJoinScreening, screening.Action, profile)
+		reply := fmt.Sprintf("%s was stopped at the door: their profile contains \"%s\" (action: %s).", user.String(), word, screening.Action)
+		msg := tgbotapi.NewMessage(chat.ID, reply)
+		b.API.Send(msg)
+		return screening.Action != ActionNone
+	}
+	return false
+}
+
+// JoinFilter lists and edits the words that are not allowed in the profile of new members
+func (b *TeleBot) JoinFilter(update tgbotapi.Update) {
+	message := update.Message
+
+	settings := b.ChatSettings(message.Chat.ID)
+	screening := settings.JoinScreening
+	args := strings.Fields(message.CommandArguments())
+
+	if len(args) == 0 {
+		reply := fmt.Sprintf("Join filter words: %s\nAction: %s\n\nUsage: /joinfilter add <word>, /joinfilter remove <word>, /joinfilter action none|mute|kick|ban",
+			strings.Join(screening.Words, ", "), screening.Action)
+		b.reply(message, reply)
+		return
+	}
+
+	switch {
+	case args[0] == "add" && len(args) == 2:
+		screening.Words = append(append([]string(nil), screening.Words...), args[1])
+	case args[0] == "remove" && len(args) == 2:
+		var words []string
+		for _, word := range screening.Words {
+			if !strings.EqualFold(word, args[1]) {
+				words = append(words, word)
+			}
+		}
+		screening.Words = words
+	case args[0] == "action" && len(args) == 2:
+		action, ok := ParseAction(args[1])
+		if !ok {
+			b.reply(message, "Unknown action. Use none, mute, kick or ban.")
+			return
+		}
+		screening.Action = action
+	default:
+		b.reply(message, "Usage: /joinfilter add <word>, /joinfilter remove <word>, /joinfilter action none|mute|kick|ban")
+		return
+	}
+
+	settings.JoinScreening = screening
+	if err := b.SaveChatSettings(message.Chat.ID, settings); err != nil {
+		b.reply(message, "Could not save the join filter. Please try again.")
+		return
+	}
+	b.reply(message, "Join filter updated.")
+}
@@ -0,0 +1,806 @@
+package structs
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps everything in memory. It lets the bot run without Postgres during development,
+// and handlers be exercised without a database. Nothing survives a restart.
+type MemoryStore struct {
+	mu     sync.Mutex
+	lastID int64 // IDs of messages, review items, moderation events, appeals, subscriptions and their matches
+
+	messages     []StoredMessage
+	messageIndex map[messageKey]int // position in messages of the messages with a Telegram message ID
+
+	bannedImages   map[int64][]uint64
+	documentHashes map[string]string
+	badFiles       map[int64]map[string]bool
+
+	reviewItems    map[int64]ReviewItem
+	strikes        map[memberKey]int
+	events         map[int64]ModerationEvent
+	appeals        map[int64]Appeal
+	appealMessages map[int64][]string
+
+	captchas  map[memberKey]CaptchaChallenge
+	lockdowns map[int64]Lockdown
+
+	members     map[memberKey]time.Time
+	roles       map[memberKey]Role
+	connections map[int64][]Connection // by user
+
+	settings map[int64][]byte // JSON, like the chat_settings table
+
+	subscriptions       map[int64]Subscription
+	subscriptionMatches map[int64][]SubscriptionMatch // by subscription
+}
+
+// memberKey identifies a user in a chat
+type memberKey struct {
+	chatID int64
+	userID int64
+}
+
+// messageKey identifies a Telegram message in a chat
+type messageKey struct {
+	chatID    int64
+	messageID int
+}
+
+// NewMemoryStore returns an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		messageIndex:   make(map[messageKey]int),
+		bannedImages:   make(map[int64][]uint64),
+		documentHashes: make(map[string]string),
+		badFiles:       make(map[int64]map[string]bool),
+		reviewItems:    make(map[int64]ReviewItem),
+		strikes:        make(map[memberKey]int),
+		events:         make(map[int64]ModerationEvent),
+		appeals:        make(map[int64]Appeal),
+		appealMessages: make(map[int64][]string),
+		captchas:       make(map[memberKey]CaptchaChallenge),
+		lockdowns:      make(map[int64]Lockdown),
+		members:        make(map[memberKey]time.Time),
+		roles:          make(map[memberKey]Role),
+		connections:    make(map[int64][]Connection),
+		settings:       make(map[int64][]byte),
+
+		subscriptions:       make(map[int64]Subscription),
+		subscriptionMatches: make(map[int64][]SubscriptionMatch),
+	}
+}
+
+// Close does nothing, it is there to satisfy Store
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// nextID returns a new ID, the caller holds the lock
+func (s *MemoryStore) nextID() int64 {
+	s.lastID++
+	return s.lastID
+}
+
+// StoreMessage stores a message together with its matches, replacing a stored message with the same ID
+func (s *MemoryStore) StoreMessage(message StoredMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message.Matches = append([]MessageMatch(nil), message.Matches...)
+	key := messageKey{message.ChatID, message.MessageID}
+	if i, ok := s.messageIndex[key]; ok {
+		stored := s.messages[i]
+		stored.Text = message.Text
+		stored.ContentType = message.ContentType
+		stored.Matches = message.Matches
+		s.messages[i] = stored
+		return nil
+	}
+	message.ID = s.nextID()
+	s.messages = append(s.messages, message)
+	if message.MessageID != 0 {
+		s.messageIndex[key] = len(s.messages) - 1
+	}
+	return nil
+}
+
+// indexMessages rebuilds the index of the messages by Telegram message ID, the caller holds the lock
+func (s *MemoryStore) indexMessages() {
+	s.messageIndex = make(map[messageKey]int)
+	for i, message := range s.messages {
+		if message.MessageID != 0 {
+			s.messageIndex[messageKey{message.ChatID, message.MessageID}] = i
+		}
+	}
+}
+
+// SearchMessages returns a page of the stored messages of some chats found by a search, best matches first.
+// The page starts after the cursor of the last result of the previous page, or at the first result if after is nil.
+func (s *MemoryStore) SearchMessages(chatIDs []int64, search SearchQuery, after *SearchCursor, limit int) ([]SearchResult, error) {
+	results := s.search(chatIDs, search)
+	if after != nil {
+		start := sort.Search(len(results), func(i int) bool { return after.Before(results[i].Cursor()) })
+		results = results[start:]
+	}
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+// CountMessages returns the number of stored messages of some chats found by a search
+func (s *MemoryStore) CountMessages(chatIDs []int64, search SearchQuery) (int, error) {
+	return len(s.search(chatIDs, search)), nil
+}
+
+// search returns every stored message of some chats found by a search, in the order of SearchCursor
+func (s *MemoryStore) search(chatIDs []int64, search SearchQuery) []SearchResult {
+	chats := make(map[int64]bool)
+	for _, chatID := range chatIDs {
+		chats[chatID] = true
+	}
+
+	terms := searchTerms(search.Terms)
+	var phrases [][]string
+	for _, phrase := range search.Phrases {
+		phrases = append(phrases, searchTerms([]string{phrase}))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var results []SearchResult
+	for _, message := range s.messages {
+		if !chats[message.ChatID] || !search.matchesFilters(message) {
+			continue
+		}
+		rank, headline, found := 0.0, message.Text, true
+		if search.HasText() {
+			rank, headline, found = matchText(message.Text, terms, phrases)
+		}
+		if !found {
+			continue
+		}
+		// Matches are not returned, like the DB does
+		message.Matches = nil
+		results = append(results, SearchResult{StoredMessage: message, Rank: rank, Headline: headline})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Cursor().Before(results[j].Cursor()) })
+	return results
+}
+
+// PurgeMessages deletes the stored messages of a chat sent before the given time
+func (s *MemoryStore) PurgeMessages(chatID int64, before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var kept []StoredMessage
+	for _, message := range s.messages {
+		if message.ChatID != chatID || !message.SentDate.Before(before) {
+			kept = append(kept, message)
+		}
+	}
+	s.messages = kept
+	s.indexMessages()
+	return nil
+}
+
+// AddBannedImage adds an image hash to a chat's image blocklist
+func (s *MemoryStore) AddBannedImage(chatID int64, hash uint64, addedBy int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bannedImages[chatID] = append(s.bannedImages[chatID], hash)
+	return nil
+}
+
+// BannedImages returns the image hashes banned in a chat
+func (s *MemoryStore) BannedImages(chatID int64) ([]uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]uint64(nil), s.bannedImages[chatID]...), nil
+}
+
+// DocumentHash returns the stored SHA-256 of a file, or an empty string if it was never hashed
+func (s *MemoryStore) DocumentHash(fileUniqueID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.documentHashes[fileUniqueID], nil
+}
+
+// StoreDocumentHash remembers the SHA-256 of a file
+func (s *MemoryStore) StoreDocumentHash(fileUniqueID string, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.documentHashes[fileUniqueID]; !ok {
+		s.documentHashes[fileUniqueID] = hash
+	}
+	return nil
+}
+
+// AddBadFile adds a SHA-256 to a chat's bad file list, chat 0 is the global list
+func (s *MemoryStore) AddBadFile(chatID int64, hash string, addedBy int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.badFiles[chatID] == nil {
+		s.badFiles[chatID] = make(map[string]bool)
+	}
+	s.badFiles[chatID][hash] = true
+	return nil
+}
+
+// HasBadFiles reports whether any bad file applies to a chat
+func (s *MemoryStore) HasBadFiles(chatID int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.badFiles[0]) > 0 || len(s.badFiles[chatID]) > 0, nil
+}
+
+// IsBadFile reports whether a SHA-256 is on the chat's or the global bad file list
+func (s *MemoryStore) IsBadFile(chatID int64, hash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.badFiles[0][hash] || s.badFiles[chatID][hash], nil
+}
+
+// CreateReviewItem stores a message sent to the review queue and returns its ID
+func (s *MemoryStore) CreateReviewItem(item ReviewItem) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item.ID = s.nextID()
+	s.reviewItems[item.ID] = item
+	return item.ID, nil
+}
+
+// GetReviewItem returns a review item, or nil if it does not exist
+func (s *MemoryStore) GetReviewItem(id int64) (*ReviewItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.reviewItems[id]
+	if !ok {
+		return nil, nil
+	}
+	return &item, nil
+}
+
+// DecideReviewItem records the decision taken on a review item
+func (s *MemoryStore) DecideReviewItem(id int64, status string, decidedBy int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if item, ok := s.reviewItems[id]; ok {
+		item.Status = status
+		item.DecidedBy = decidedBy
+		s.reviewItems[id] = item
+	}
+	return nil
+}
+
+// ReviewStats counts the decisions taken on the review items of a chat, per rule
+func (s *MemoryStore) ReviewStats(chatID int64) ([]ReviewStat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byRule := make(map[string]*ReviewStat)
+	for _, item := range s.reviewItems {
+		if item.ChatID != chatID {
+			continue
+		}
+		stat, ok := byRule[item.Rule]
+		if !ok {
+			stat = &ReviewStat{Rule: item.Rule}
+			byRule[item.Rule] = stat
+		}
+		switch item.Status {
+		case ReviewApproved:
+			stat.Approved++
+		case ReviewRejected:
+			stat.Rejected++
+		case ReviewBanned:
+			stat.Banned++
+		case ReviewPending:
+			stat.Pending++
+		}
+	}
+
+	var stats []ReviewStat
+	for _, stat := range byRule {
+		stats = append(stats, *stat)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Rule < stats[j].Rule })
+	return stats, nil
+}
+
+// AddStrike adds a strike to a user in a chat and returns their number of strikes
+func (s *MemoryStore) AddStrike(chatID int64, userID int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := memberKey{chatID, userID}
+	s.strikes[key]++
+	return s.strikes[key], nil
+}
+
+// ResetStrikes clears the strikes of a user in a chat
+func (s *MemoryStore) ResetStrikes(chatID int64, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.strikes, memberKey{chatID, userID})
+	return nil
+}
+
+// RecordModeration stores a moderation event and returns its ID
+func (s *MemoryStore) RecordModeration(event ModerationEvent) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	event.ID = s.nextID()
+	s.events[event.ID] = event
+	return event.ID, nil
+}
+
+// UserModerations returns the latest moderation events of a user across all chats
+func (s *MemoryStore) UserModerations(userID int64, limit int) ([]ModerationEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []ModerationEvent
+	for _, event := range s.events {
+		if event.UserID == userID {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].CreatedAt.After(events[j].CreatedAt) })
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// GetModeration returns a moderation event, or nil if it does not exist
+func (s *MemoryStore) GetModeration(id int64) (*ModerationEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	event, ok := s.events[id]
+	if !ok {
+		return nil, nil
+	}
+	return &event, nil
+}
+
+// CreateAppeal stores a new appeal and returns its ID
+func (s *MemoryStore) CreateAppeal(appeal Appeal) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	appeal.ID = s.nextID()
+	s.appeals[appeal.ID] = appeal
+	return appeal.ID, nil
+}
+
+// GetAppeal returns an appeal, or nil if it does not exist
+func (s *MemoryStore) GetAppeal(id int64) (*Appeal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	appeal, ok := s.appeals[id]
+	if !ok {
+		return nil, nil
+	}
+	return &appeal, nil
+}
+
+// PendingAppeal returns the undecided appeal of a moderation event, or nil if there is none
+func (s *MemoryStore) PendingAppeal(eventID int64) (*Appeal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, appeal := range s.appeals {
+		if appeal.EventID == eventID && appeal.Status == AppealPending {
+			return &appeal, nil
+		}
+	}
+	return nil, nil
+}
+
+// DecideAppeal records the decision taken on an appeal
+func (s *MemoryStore) DecideAppeal(id int64, status string, decidedBy int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if appeal, ok := s.appeals[id]; ok {
+		appeal.Status = status
+		appeal.DecidedBy = decidedBy
+		s.appeals[id] = appeal
+	}
+	return nil
+}
+
+// AddAppealMessage adds a message to the thread of an appeal
+func (s *MemoryStore) AddAppealMessage(appealID int64, senderID int64, text string, sentDate time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.appealMessages[appealID] = append(s.appealMessages[appealID], text)
+	return nil
+}
+
+// SaveCaptchaChallenge creates or updates the pending challenge of a new member
+func (s *MemoryStore) SaveCaptchaChallenge(challenge CaptchaChallenge) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.captchas[memberKey{challenge.ChatID, challenge.UserID}] = challenge
+	return nil
+}
+
+// GetCaptchaChallenge returns the pending challenge of a member, or nil if there is none
+func (s *MemoryStore) GetCaptchaChallenge(chatID int64, userID int64) (*CaptchaChallenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	challenge, ok := s.captchas[memberKey{chatID, userID}]
+	if !ok {
+		return nil, nil
+	}
+	return &challenge, nil
+}
+
+// DeleteCaptchaChallenge removes the challenge of a member
+func (s *MemoryStore) DeleteCaptchaChallenge(chatID int64, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.captchas, memberKey{chatID, userID})
+	return nil
+}
+
+// ExpiredCaptchaChallenges returns the challenges that expired before the given time
+func (s *MemoryStore) ExpiredCaptchaChallenges(now time.Time) ([]CaptchaChallenge, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var challenges []CaptchaChallenge
+	for _, challenge := range s.captchas {
+		if !challenge.ExpiresAt.After(now) {
+			challenges = append(challenges, challenge)
+		}
+	}
+	return challenges, nil
+}
+
+// SaveLockdown stores a chat lockdown
+func (s *MemoryStore) SaveLockdown(lockdown Lockdown) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lockdowns[lockdown.ChatID] = lockdown
+	return nil
+}
+
+// GetLockdown returns the lockdown of a chat, or nil if the chat is not locked
+func (s *MemoryStore) GetLockdown(chatID int64) (*Lockdown, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lockdown, ok := s.lockdowns[chatID]
+	if !ok {
+		return nil, nil
+	}
+	return &lockdown, nil
+}
+
+// DeleteLockdown removes the lockdown of a chat
+func (s *MemoryStore) DeleteLockdown(chatID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.lockdowns, chatID)
+	return nil
+}
+
+// ExpiredLockdowns returns the lockdowns whose cool-down ended before the given time
+func (s *MemoryStore) ExpiredLockdowns(now time.Time) ([]Lockdown, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var lockdowns []Lockdown
+	for _, lockdown := range s.lockdowns {
+		if !lockdown.Until.After(now) {
+			lockdowns = append(lockdowns, lockdown)
+		}
+	}
+	return lockdowns, nil
+}
+
+// TouchMember records the first time a user was seen in a chat and returns it
+func (s *MemoryStore) TouchMember(chatID int64, userID int64, seen time.Time) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := memberKey{chatID, userID}
+	if firstSeen, ok := s.members[key]; ok {
+		return firstSeen, nil
+	}
+	s.members[key] = seen
+	return seen, nil
+}
+
+// MemberChats returns the groups a user was seen in, most recently joined first
+func (s *MemoryStore) MemberChats(userID int64) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []memberKey
+	for key := range s.members {
+		if key.userID == userID {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return s.members[keys[i]].After(s.members[keys[j]]) })
+
+	chats := make([]int64, len(keys))
+	for i, key := range keys {
+		chats[i] = key.chatID
+	}
+	return chats, nil
+}
+
+// ForgetMember deletes what is known about a user in a chat they left
+func (s *MemoryStore) ForgetMember(chatID int64, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.members, memberKey{chatID, userID})
+	return nil
+}
+
+// GetRole returns the role stored for a user in a chat, RoleUser if there is none
+func (s *MemoryStore) GetRole(chatID int64, userID int64) (Role, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.roles[memberKey{chatID, userID}], nil
+}
+
+// SetRole stores the role of a user in a chat
+func (s *MemoryStore) SetRole(chatID int64, userID int64, role Role) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.roles[memberKey{chatID, userID}] = role
+	return nil
+}
+
+// DeleteRole removes the stored role of a user in a chat
+func (s *MemoryStore) DeleteRole(chatID int64, userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.roles, memberKey{chatID, userID})
+	return nil
+}
+
+// SaveConnection links the private chat of a user to a group and makes it the active one
+func (s *MemoryStore) SaveConnection(connection Connection) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var connections []Connection
+	for _, existing := range s.connections[connection.UserID] {
+		if existing.ChatID == connection.ChatID {
+			// Keep the first connection date, like the DB does
+			connection.ConnectedDate = existing.ConnectedDate
+			continue
+		}
+		existing.Active = false
+		connections = append(connections, existing)
+	}
+	connection.Active = true
+	s.connections[connection.UserID] = append(connections, connection)
+	return nil
+}
+
+// SetActiveConnection makes one of the connected groups of a user the one their commands apply to
+func (s *MemoryStore) SetActiveConnection(userID int64, chatID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.connections[userID] {
+		s.connections[userID][i].Active = s.connections[userID][i].ChatID == chatID
+	}
+	return nil
+}
+
+// Connections returns the groups a user is connected to
+func (s *MemoryStore) Connections(userID int64) ([]Connection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	connections := append([]Connection(nil), s.connections[userID]...)
+	sort.Slice(connections, func(i, j int) bool { return connections[i].ChatTitle < connections[j].ChatTitle })
+	return connections, nil
+}
+
+// ActiveConnection returns the group the commands of a user apply to, or nil if they are not connected
+func (s *MemoryStore) ActiveConnection(userID int64) (*Connection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, connection := range s.connections[userID] {
+		if connection.Active {
+			return &connection, nil
+		}
+	}
+	return nil, nil
+}
+
+// DeleteConnection unlinks the private chat of a user from a group
+func (s *MemoryStore) DeleteConnection(userID int64, chatID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var connections []Connection
+	for _, connection := range s.connections[userID] {
+		if connection.ChatID != chatID {
+			connections = append(connections, connection)
+		}
+	}
+	s.connections[userID] = connections
+	return nil
+}
+
+// GetChatSettings returns the stored settings of a chat, or the defaults if it has none
+func (s *MemoryStore) GetChatSettings(chatID int64) (ChatSettings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	settings := DefaultChatSettings()
+	data, ok := s.settings[chatID]
+	if !ok {
+		return settings, nil
+	}
+	// Going through JSON keeps stored settings from sharing slices and maps with the caller
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return DefaultChatSettings(), err
+	}
+	return settings, nil
+}
+
+// SaveChatSettings creates or replaces the settings of a chat
+func (s *MemoryStore) SaveChatSettings(chatID int64, settings ChatSettings) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings[chatID] = data
+	return nil
+}
+
+// ChatsWithRetention returns the retention period in days of every chat that does not keep its messages forever
+func (s *MemoryStore) ChatsWithRetention() (map[int64]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chats := make(map[int64]int)
+	for chatID, data := range s.settings {
+		var settings ChatSettings
+		if err := json.Unmarshal(data, &settings); err != nil {
+			return nil, err
+		}
+		if settings.RetentionDays > 0 {
+			chats[chatID] = settings.RetentionDays
+		}
+	}
+	return chats, nil
+}
+
+// CreateSubscription stores a search a user subscribed to and returns its ID
+func (s *MemoryStore) CreateSubscription(subscription Subscription) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscription.ID = s.nextID()
+	s.subscriptions[subscription.ID] = subscription
+	return subscription.ID, nil
+}
+
+// GetSubscription returns a subscription, or nil if it does not exist
+func (s *MemoryStore) GetSubscription(id int64) (*Subscription, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscription, ok := s.subscriptions[id]
+	if !ok {
+		return nil, nil
+	}
+	return &subscription, nil
+}
+
+// UserSubscriptions returns the subscriptions of a user, oldest first
+func (s *MemoryStore) UserSubscriptions(userID int64) ([]Subscription, error) {
+	return s.filterSubscriptions(func(subscription Subscription) bool { return subscription.UserID == userID }), nil
+}
+
+// ChatSubscriptions returns the subscriptions to the messages of a chat
+func (s *MemoryStore) ChatSubscriptions(chatID int64) ([]Subscription, error) {
+	return s.filterSubscriptions(func(subscription Subscription) bool { return subscription.ChatID == chatID }), nil
+}
+
+// filterSubscriptions returns the subscriptions kept by keep, oldest first
+func (s *MemoryStore) filterSubscriptions(keep func(Subscription) bool) []Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var subscriptions []Subscription
+	for _, subscription := range s.subscriptions {
+		if keep(subscription) {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	sort.Slice(subscriptions, func(i, j int) bool { return subscriptions[i].ID < subscriptions[j].ID })
+	return subscriptions
+}
+
+// SetSubscriptionDigest switches a subscription between immediate alerts and a digest
+func (s *MemoryStore) SetSubscriptionDigest(id int64, digest bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if subscription, ok := s.subscriptions[id]; ok {
+		subscription.Digest = digest
+		s.subscriptions[id] = subscription
+	}
+	return nil
+}
+
+// DeleteSubscription removes a subscription and the matches waiting for its digest
+func (s *MemoryStore) DeleteSubscription(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscriptions, id)
+	delete(s.subscriptionMatches, id)
+	return nil
+}
+
+// AddSubscriptionMatch keeps a message found by a subscription until its digest is sent
+func (s *MemoryStore) AddSubscriptionMatch(match SubscriptionMatch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subscription, ok := s.subscriptions[match.SubscriptionID]
+	if !ok {
+		return nil
+	}
+	// Only what the DB keeps of the message
+	match.ID = s.nextID()
+	match.Result = SearchResult{
+		StoredMessage: StoredMessage{
+			ChatID:     subscription.ChatID,
+			MessageID:  match.Result.MessageID,
+			SenderID:   match.Result.SenderID,
+			Username:   match.Result.Username,
+			SenderName: match.Result.SenderName,
+			ChatType:   match.Result.ChatType,
+			Text:       match.Result.Headline,
+			SentDate:   match.Result.SentDate,
+		},
+		Headline: match.Result.Headline,
+	}
+	s.subscriptionMatches[match.SubscriptionID] = append(s.subscriptionMatches[match.SubscriptionID], match)
+	return nil
+}
+
+// SubscriptionMatches returns the matches waiting for the digest of a subscription, oldest first
+func (s *MemoryStore) SubscriptionMatches(subscriptionID int64) ([]SubscriptionMatch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SubscriptionMatch(nil), s.subscriptionMatches[subscriptionID]...), nil
+}
+
+// ClearSubscriptionMatches forgets the matches of a subscription up to throughID once its digest was sent
+func (s *MemoryStore) ClearSubscriptionMatches(subscriptionID int64, throughID int64, sent time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var kept []SubscriptionMatch
+	for _, match := range s.subscriptionMatches[subscriptionID] {
+		if match.ID > throughID {
+			kept = append(kept, match)
+		}
+	}
+	if len(kept) == 0 {
+		delete(s.subscriptionMatches, subscriptionID)
+	} else {
+		s.subscriptionMatches[subscriptionID] = kept
+	}
+	if subscription, ok := s.subscriptions[subscriptionID]; ok {
+		subscription.LastDigest = sent
+		s.subscriptions[subscriptionID] = subscription
+	}
+	return nil
+}
+
+// DueDigests returns the subscriptions with matches waiting whose last digest was sent before the given time
+func (s *MemoryStore) DueDigests(before time.Time) ([]Subscription, error) {
+	return s.filterSubscriptions(func(subscription Subscription) bool {
+		return len(s.subscriptionMatches[subscription.ID]) > 0 && !subscription.LastDigest.After(before)
+	}), nil
+}
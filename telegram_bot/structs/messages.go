@@ -0,0 +1,111 @@
+package structs
+
+import (
+	"strings"
+	"time"
+	"unicode"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// StoredMessage is a message kept in the messages table for /show and search
+type StoredMessage struct {
+	ID          int64 // assigned by the store
+	ChatID      int64
+	MessageID   int // 0 for messages stored before message IDs were kept
+	SenderID    int64
+	Username    string
+	SenderName  string // first and last name of the sender
+	ChatType    string
+	ContentType string // text, photo, document, ...
+	Text        string // text or caption
+	ReplyTo     int    // ID of the replied message, 0 if none
+	ThreadID    int    // forum topic, 0 if none
+	SentDate    time.Time
+	Language    string // language of the chat, picks how the text is indexed for search
+	Matches     []MessageMatch
+}
+
+// MessageMatch records where a rule matched in a stored message
+type MessageMatch struct {
+	Rule    string
+	Pattern string // what the rule looked for, e.g. the filter word
+	Start   int    // offsets in characters of the matched text
+	End     int
+}
+
+// NewStoredMessage returns the stored form of a Telegram message
+func NewStoredMessage(message *tgbotapi.Message) StoredMessage {
+	stored := StoredMessage{
+		ChatID:      message.Chat.ID,
+		MessageID:   message.MessageID,
+		ChatType:    message.Chat.Type,
+		ContentType: contentType(message),
+		Text:        message.Text,
+		SentDate:    message.Time(),
+	}
+	if stored.Text == "" {
+		stored.Text = message.Caption
+	}
+	if message.From != nil {
+		stored.SenderID = message.From.ID
+		stored.Username = message.From.UserName
+		stored.SenderName = strings.TrimSpace(message.From.FirstName + " " + message.From.LastName)
+	}
+	if message.ReplyToMessage != nil {
+		stored.ReplyTo = message.ReplyToMessage.MessageID
+	}
+	// The Bot API version in use does not report forum topics, ThreadID stays 0 until it does
+	return stored
+}
+
+// contentType names the kind of content of a message
+func contentType(message *tgbotapi.Message) string {
+	switch {
+	case message.Photo != nil:
+		return "photo"
+	case message.Animation != nil:
+		return "animation"
+	case message.Document != nil:
+		return "document"
+	case message.Sticker != nil:
+		return "sticker"
+	case message.Video != nil:
+		return "video"
+	case message.VideoNote != nil:
+		return "video_note"
+	case message.Voice != nil:
+		return "voice"
+	case message.Audio != nil:
+		return "audio"
+	case message.Contact != nil:
+		return "contact"
+	case message.Location != nil:
+		return "location"
+	case message.Poll != nil:
+		return "poll"
+	case message.Text != "":
+		return "text"
+	}
+	return "other"
+}
+
+// FindWord returns the character offsets of the first occurrence of word as a whole word in text, ignoring case
+func FindWord(text string, word string) (start int, end int, found bool) {
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && !unicode.IsSpace(runes[j]) {
+			j++
+		}
+		if strings.EqualFold(string(runes[i:j]), word) {
+			return i, j, true
+		}
+		i = j
+	}
+	return 0, 0, false
+}
@@ -0,0 +1,284 @@
+package structs
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFiles holds the schema migrations, named <version>_<name>.up.sql and <version>_<name>.down.sql
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the key of the advisory lock held while migrating, so two bots starting together do not race
+const migrationLockID = 727130001
+
+// Migration is one version of the database schema
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string // SHA-256 of Up, to detect migrations edited after they were applied
+}
+
+// AppliedMigration is a migration recorded in schema_migrations
+type AppliedMigration struct {
+	Version   int
+	Name      string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// Migrations returns the embedded migrations in version order
+func Migrations() ([]Migration, error) {
+	files, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, file := range files {
+		base := strings.TrimPrefix(file, "migrations/")
+		var direction string
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s is neither .up.sql nor .down.sql", base)
+		}
+
+		prefix, name, ok := strings.Cut(strings.TrimSuffix(base, "."+direction+".sql"), "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s is not named <version>_<name>", base)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has no numeric version", base)
+		}
+
+		data, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		}
+		if migration.Name != name {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, name)
+		}
+		if direction == "up" {
+			migration.Up = string(data)
+			sum := sha256.Sum256(data)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.Down = string(data)
+		}
+	}
+
+	var migrations []Migration
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up file", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// migrator runs migrations on a single connection, which holds the advisory lock
+type migrator struct {
+	conn       *sql.Conn
+	migrations []Migration
+}
+
+// withMigrator locks the schema, verifies the applied migrations and calls fn
+func (db *DB) withMigrator(fn func(m *migrator) error) error {
+	ctx := context.Background()
+
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	// Advisory locks belong to a session, so everything runs on the connection that took it
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("locking schema: %w", err)
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockID)
+
+	query := `
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version    INTEGER PRIMARY KEY,
+            name       TEXT NOT NULL,
+            checksum   TEXT NOT NULL,
+            applied_at TIMESTAMPTZ NOT NULL
+        )
+    `
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return err
+	}
+
+	m := &migrator{conn: conn, migrations: migrations}
+	if err := m.verify(); err != nil {
+		return err
+	}
+	return fn(m)
+}
+
+// applied returns the migrations recorded in schema_migrations in version order
+func (m *migrator) applied() ([]AppliedMigration, error) {
+	rows, err := m.conn.QueryContext(context.Background(), "SELECT version, name, checksum, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var applied []AppliedMigration
+	for rows.Next() {
+		var migration AppliedMigration
+		if err := rows.Scan(&migration.Version, &migration.Name, &migration.Checksum, &migration.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied = append(applied, migration)
+	}
+	return applied, rows.Err()
+}
+
+// verify refuses to go on when an applied migration was changed or is unknown to this binary
+func (m *migrator) verify() error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	known := make(map[int]Migration)
+	for _, migration := range m.migrations {
+		known[migration.Version] = migration
+	}
+	for _, done := range applied {
+		migration, ok := known[done.Version]
+		if !ok {
+			return fmt.Errorf("migration %d (%s) is applied but unknown to this version of the bot", done.Version, done.Name)
+		}
+		if migration.Checksum != done.Checksum {
+			return fmt.Errorf("migration %d (%s) was changed after it was applied", done.Version, done.Name)
+		}
+	}
+	return nil
+}
+
+// run executes a migration script and records or forgets it in one transaction
+func (m *migrator) run(migration Migration, up bool) error {
+	ctx := context.Background()
+	tx, err := m.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	script := migration.Up
+	if !up {
+		script = migration.Down
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Name, err)
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES ($1, $2, $3, $4)",
+			migration.Version, migration.Name, migration.Checksum, time.Now())
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MigrateUp applies every migration that was not applied yet
+func (db *DB) MigrateUp() error {
+	return db.withMigrator(func(m *migrator) error {
+		applied, err := m.applied()
+		if err != nil {
+			return err
+		}
+		done := make(map[int]bool)
+		for _, migration := range applied {
+			done[migration.Version] = true
+		}
+
+		for _, migration := range m.migrations {
+			if done[migration.Version] {
+				continue
+			}
+			if err := m.run(migration, true); err != nil {
+				return err
+			}
+			log.Printf("Applied migration %d (%s)", migration.Version, migration.Name)
+		}
+		return nil
+	})
+}
+
+// MigrateDown reverts the given number of most recent migrations
+func (db *DB) MigrateDown(steps int) error {
+	return db.withMigrator(func(m *migrator) error {
+		applied, err := m.applied()
+		if err != nil {
+			return err
+		}
+
+		known := make(map[int]Migration)
+		for _, migration := range m.migrations {
+			known[migration.Version] = migration
+		}
+		for i := len(applied) - 1; i >= 0 && steps > 0; i, steps = i-1, steps-1 {
+			migration := known[applied[i].Version]
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d (%s) cannot be reverted, it has no down file", migration.Version, migration.Name)
+			}
+			if err := m.run(migration, false); err != nil {
+				return err
+			}
+			log.Printf("Reverted migration %d (%s)", migration.Version, migration.Name)
+		}
+		return nil
+	})
+}
+
+// MigrationStatus returns the embedded migrations and the ones applied to the database
+func (db *DB) MigrationStatus() ([]Migration, []AppliedMigration, error) {
+	var migrations []Migration
+	var applied []AppliedMigration
+	err := db.withMigrator(func(m *migrator) error {
+		var err error
+		migrations = m.migrations
+		applied, err = m.applied()
+		return err
+	})
+	return migrations, applied, err
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS messages_without_word;
+DROP TABLE IF EXISTS messages_with_word;
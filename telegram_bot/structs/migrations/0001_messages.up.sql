@@ -0,0 +1,25 @@
+-- Messages checked against the filter word, split by whether they contained it.
+-- Older installations created these tables by hand, without chat_id.
+CREATE TABLE IF NOT EXISTS messages_with_word (
+    id           BIGSERIAL PRIMARY KEY,
+    chat_id      BIGINT,
+    sender_id    BIGINT NOT NULL,
+    message_text TEXT NOT NULL,
+    sent_date    TIMESTAMPTZ NOT NULL,
+    filter_word  TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS messages_without_word (
+    id           BIGSERIAL PRIMARY KEY,
+    chat_id      BIGINT,
+    sender_id    BIGINT NOT NULL,
+    message_text TEXT NOT NULL,
+    sent_date    TIMESTAMPTZ NOT NULL,
+    filter_word  TEXT NOT NULL
+);
+
+ALTER TABLE messages_with_word ADD COLUMN IF NOT EXISTS chat_id BIGINT;
+ALTER TABLE messages_without_word ADD COLUMN IF NOT EXISTS chat_id BIGINT;
+
+CREATE INDEX IF NOT EXISTS messages_with_word_chat_idx ON messages_with_word (chat_id, sent_date);
+CREATE INDEX IF NOT EXISTS messages_without_word_chat_idx ON messages_without_word (chat_id, sent_date);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS connections;
+DROP TABLE IF EXISTS chat_roles;
+DROP TABLE IF EXISTS chat_members;
+DROP TABLE IF EXISTS chat_settings;
@@ -0,0 +1,28 @@
+-- Per-chat settings, members, roles and the private chats connected to groups
+CREATE TABLE IF NOT EXISTS chat_settings (
+    chat_id  BIGINT PRIMARY KEY,
+    settings JSONB NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS chat_members (
+    chat_id    BIGINT NOT NULL,
+    user_id    BIGINT NOT NULL,
+    first_seen TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (chat_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS chat_roles (
+    chat_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    role    TEXT NOT NULL,
+    PRIMARY KEY (chat_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS connections (
+    user_id        BIGINT NOT NULL,
+    chat_id        BIGINT NOT NULL,
+    chat_title     TEXT NOT NULL DEFAULT '',
+    active         BOOLEAN NOT NULL DEFAULT FALSE,
+    connected_date TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (user_id, chat_id)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS bad_files;
+DROP TABLE IF EXISTS document_hashes;
+DROP TABLE IF EXISTS banned_images;
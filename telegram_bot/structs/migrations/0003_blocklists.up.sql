@@ -0,0 +1,23 @@
+-- Banned images and files. bad_files rows with chat_id 0 apply to every chat.
+CREATE TABLE IF NOT EXISTS banned_images (
+    id         BIGSERIAL PRIMARY KEY,
+    chat_id    BIGINT NOT NULL,
+    image_hash BIGINT NOT NULL,
+    added_by   BIGINT NOT NULL,
+    added_date TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS banned_images_chat_idx ON banned_images (chat_id);
+
+CREATE TABLE IF NOT EXISTS document_hashes (
+    file_unique_id TEXT PRIMARY KEY,
+    sha256         TEXT NOT NULL,
+    hashed_date    TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS bad_files (
+    chat_id    BIGINT NOT NULL,
+    sha256     TEXT NOT NULL,
+    added_by   BIGINT NOT NULL,
+    added_date TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (chat_id, sha256)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS lockdowns;
+DROP TABLE IF EXISTS captcha_challenges;
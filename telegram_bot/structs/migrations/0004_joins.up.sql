@@ -0,0 +1,17 @@
+-- Pending captcha challenges and chat lockdowns, kept so they survive restarts
+CREATE TABLE IF NOT EXISTS captcha_challenges (
+    chat_id    BIGINT NOT NULL,
+    user_id    BIGINT NOT NULL,
+    message_id INTEGER NOT NULL,
+    answer     TEXT NOT NULL,
+    attempts   INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (chat_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS captcha_challenges_expires_idx ON captcha_challenges (expires_at);
+
+CREATE TABLE IF NOT EXISTS lockdowns (
+    chat_id     BIGINT PRIMARY KEY,
+    until       TIMESTAMPTZ NOT NULL,
+    permissions JSONB NOT NULL
+);
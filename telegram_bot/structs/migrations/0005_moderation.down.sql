@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS appeal_messages;
+DROP TABLE IF EXISTS appeals;
+DROP TABLE IF EXISTS moderation_events;
+DROP TABLE IF EXISTS strikes;
+DROP TABLE IF EXISTS review_items;
@@ -0,0 +1,57 @@
+-- Review queue, strikes, moderation history and appeals
+CREATE TABLE IF NOT EXISTS review_items (
+    id             BIGSERIAL PRIMARY KEY,
+    chat_id        BIGINT NOT NULL,
+    message_id     INTEGER NOT NULL,
+    user_id        BIGINT NOT NULL,
+    user_name      TEXT NOT NULL DEFAULT '',
+    rule           TEXT NOT NULL,
+    message_text   TEXT NOT NULL DEFAULT '',
+    review_chat_id BIGINT NOT NULL,
+    copy_id        INTEGER NOT NULL,
+    status         TEXT NOT NULL,
+    decided_by     BIGINT NOT NULL DEFAULT 0,
+    created_date   TIMESTAMPTZ NOT NULL,
+    decided_date   TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS review_items_chat_idx ON review_items (chat_id);
+
+CREATE TABLE IF NOT EXISTS strikes (
+    chat_id BIGINT NOT NULL,
+    user_id BIGINT NOT NULL,
+    strikes INTEGER NOT NULL,
+    PRIMARY KEY (chat_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS moderation_events (
+    id           BIGSERIAL PRIMARY KEY,
+    chat_id      BIGINT NOT NULL,
+    chat_title   TEXT NOT NULL DEFAULT '',
+    user_id      BIGINT NOT NULL,
+    rule         TEXT NOT NULL,
+    action       TEXT NOT NULL,
+    message_text TEXT NOT NULL DEFAULT '',
+    created_date TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS moderation_events_user_idx ON moderation_events (user_id, created_date);
+
+CREATE TABLE IF NOT EXISTS appeals (
+    id           BIGSERIAL PRIMARY KEY,
+    event_id     BIGINT NOT NULL REFERENCES moderation_events (id) ON DELETE CASCADE,
+    chat_id      BIGINT NOT NULL,
+    user_id      BIGINT NOT NULL,
+    reason       TEXT NOT NULL,
+    status       TEXT NOT NULL,
+    decided_by   BIGINT NOT NULL DEFAULT 0,
+    created_date TIMESTAMPTZ NOT NULL,
+    decided_date TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS appeals_event_idx ON appeals (event_id);
+
+CREATE TABLE IF NOT EXISTS appeal_messages (
+    id           BIGSERIAL PRIMARY KEY,
+    appeal_id    BIGINT NOT NULL REFERENCES appeals (id) ON DELETE CASCADE,
+    sender_id    BIGINT NOT NULL,
+    message_text TEXT NOT NULL,
+    sent_date    TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,32 @@
+CREATE TABLE messages_with_word (
+    id           BIGSERIAL PRIMARY KEY,
+    chat_id      BIGINT,
+    sender_id    BIGINT NOT NULL,
+    message_text TEXT NOT NULL,
+    sent_date    TIMESTAMPTZ NOT NULL,
+    filter_word  TEXT NOT NULL
+);
+
+CREATE TABLE messages_without_word (
+    id           BIGSERIAL PRIMARY KEY,
+    chat_id      BIGINT,
+    sender_id    BIGINT NOT NULL,
+    message_text TEXT NOT NULL,
+    sent_date    TIMESTAMPTZ NOT NULL,
+    filter_word  TEXT NOT NULL
+);
+
+CREATE INDEX messages_with_word_chat_idx ON messages_with_word (chat_id, sent_date);
+CREATE INDEX messages_without_word_chat_idx ON messages_without_word (chat_id, sent_date);
+
+INSERT INTO messages_with_word (chat_id, sender_id, message_text, sent_date, filter_word)
+SELECT m.chat_id, m.sender_id, m.message_text, m.sent_date, mm.pattern
+FROM messages m JOIN message_matches mm ON mm.message_id = m.id AND mm.rule = 'filter_word';
+
+INSERT INTO messages_without_word (chat_id, sender_id, message_text, sent_date, filter_word)
+SELECT m.chat_id, m.sender_id, m.message_text, m.sent_date, ''
+FROM messages m
+WHERE NOT EXISTS (SELECT 1 FROM message_matches mm WHERE mm.message_id = m.id AND mm.rule = 'filter_word');
+
+DROP TABLE message_matches;
+DROP TABLE messages;
@@ -0,0 +1,46 @@
+-- One table for every stored message, and the rule matches found in them.
+-- Rows moved over from the old tables have no message_id, Telegram did not give it to us back then.
+CREATE TABLE IF NOT EXISTS messages (
+    id           BIGSERIAL PRIMARY KEY,
+    chat_id      BIGINT NOT NULL,
+    message_id   BIGINT,
+    sender_id    BIGINT NOT NULL,
+    username     TEXT NOT NULL DEFAULT '',
+    chat_type    TEXT NOT NULL DEFAULT '',
+    content_type TEXT NOT NULL DEFAULT 'text',
+    message_text TEXT NOT NULL DEFAULT '',
+    reply_to     BIGINT,
+    thread_id    BIGINT,
+    sent_date    TIMESTAMPTZ NOT NULL,
+    UNIQUE (chat_id, message_id)
+);
+CREATE INDEX IF NOT EXISTS messages_chat_date_idx ON messages (chat_id, sent_date);
+
+CREATE TABLE IF NOT EXISTS message_matches (
+    id           BIGSERIAL PRIMARY KEY,
+    message_id   BIGINT NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+    rule         TEXT NOT NULL,
+    pattern      TEXT NOT NULL,
+    start_offset INTEGER, -- in characters, unknown for moved rows
+    end_offset   INTEGER
+);
+CREATE INDEX IF NOT EXISTS message_matches_message_idx ON message_matches (message_id);
+CREATE INDEX IF NOT EXISTS message_matches_pattern_idx ON message_matches (rule, lower(pattern));
+
+-- Remember which old row became which message to move its match along
+ALTER TABLE messages ADD COLUMN legacy_id BIGINT;
+
+INSERT INTO messages (chat_id, sender_id, message_text, sent_date, legacy_id)
+SELECT COALESCE(chat_id, 0), sender_id, message_text, sent_date, id FROM messages_with_word;
+
+INSERT INTO message_matches (message_id, rule, pattern)
+SELECT m.id, 'filter_word', w.filter_word
+FROM messages m JOIN messages_with_word w ON w.id = m.legacy_id;
+
+INSERT INTO messages (chat_id, sender_id, message_text, sent_date)
+SELECT COALESCE(chat_id, 0), sender_id, message_text, sent_date FROM messages_without_word;
+
+ALTER TABLE messages DROP COLUMN legacy_id;
+
+DROP TABLE messages_with_word;
+DROP TABLE messages_without_word;
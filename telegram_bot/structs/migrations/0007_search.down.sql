@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS messages_search_idx;
+ALTER TABLE messages DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE messages DROP COLUMN IF EXISTS search_config;
+DROP TEXT SEARCH CONFIGURATION IF EXISTS persian;
+DROP FUNCTION IF EXISTS persian_normalize(TEXT);
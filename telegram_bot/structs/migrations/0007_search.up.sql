@@ -0,0 +1,23 @@
+-- Full-text search over the stored messages.
+-- Persian is typed with Arabic or Persian forms of some letters and digits, with or without diacritics,
+-- tatweel and zero-width non-joiners: persian_normalize folds these so every spelling finds the others.
+CREATE OR REPLACE FUNCTION persian_normalize(input TEXT) RETURNS TEXT
+LANGUAGE sql IMMUTABLE STRICT PARALLEL SAFE AS $$
+    SELECT translate(input,
+        'يىكۀة٠١٢٣٤٥٦٧٨٩۰۱۲۳۴۵۶۷۸۹' || chr(8204) || chr(1600) || chr(1611) || chr(1612) || chr(1613) ||
+            chr(1614) || chr(1615) || chr(1616) || chr(1617) || chr(1618),
+        'ییکهه01234567890123456789')
+$$;
+
+-- Persian has no stemmer in Postgres, its configuration starts as a copy of simple so it can be refined later
+CREATE TEXT SEARCH CONFIGURATION persian (COPY = pg_catalog.simple);
+
+-- The configuration follows the language of the chat when the message was stored
+ALTER TABLE messages ADD COLUMN search_config REGCONFIG NOT NULL DEFAULT 'simple';
+UPDATE messages m SET search_config = 'persian'
+FROM chat_settings s
+WHERE s.chat_id = m.chat_id AND s.settings->>'language' = 'fa';
+
+ALTER TABLE messages ADD COLUMN search_vector TSVECTOR
+    GENERATED ALWAYS AS (to_tsvector(search_config, persian_normalize(message_text))) STORED;
+CREATE INDEX IF NOT EXISTS messages_search_idx ON messages USING GIN (search_vector);
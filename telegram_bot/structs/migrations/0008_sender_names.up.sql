@@ -0,0 +1,2 @@
+-- Search results show who sent a message by name, messages stored before keep an empty one
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS sender_name TEXT NOT NULL DEFAULT '';
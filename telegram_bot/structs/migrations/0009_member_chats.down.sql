@@ -0,0 +1 @@
+DROP INDEX IF EXISTS chat_members_user_idx;
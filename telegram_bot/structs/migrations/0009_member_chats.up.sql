@@ -0,0 +1,2 @@
+-- Inline searches look up the chats of a user
+CREATE INDEX IF NOT EXISTS chat_members_user_idx ON chat_members (user_id);
@@ -0,0 +1,26 @@
+-- Searches users subscribed to, and the matches waiting for their digest
+CREATE TABLE IF NOT EXISTS subscriptions (
+    id           BIGSERIAL PRIMARY KEY,
+    user_id      BIGINT NOT NULL,
+    chat_id      BIGINT NOT NULL,
+    chat_title   TEXT NOT NULL DEFAULT '',
+    query        TEXT NOT NULL,
+    digest       BOOLEAN NOT NULL DEFAULT FALSE,
+    created_date TIMESTAMPTZ NOT NULL,
+    last_digest  TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS subscriptions_user_idx ON subscriptions (user_id);
+CREATE INDEX IF NOT EXISTS subscriptions_chat_idx ON subscriptions (chat_id);
+
+CREATE TABLE IF NOT EXISTS subscription_matches (
+    id              BIGSERIAL PRIMARY KEY,
+    subscription_id BIGINT NOT NULL REFERENCES subscriptions (id) ON DELETE CASCADE,
+    message_id      INTEGER NOT NULL,
+    sender_id       BIGINT NOT NULL,
+    sender_name     TEXT NOT NULL DEFAULT '',
+    username        TEXT NOT NULL DEFAULT '',
+    chat_type       TEXT NOT NULL DEFAULT '',
+    headline        TEXT NOT NULL,
+    sent_date       TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS subscription_matches_subscription_idx ON subscription_matches (subscription_id);
@@ -0,0 +1,240 @@
+package structs
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Action is what the bot does to a user who broke a rule
+type Action string
+
+const (
+	ActionNone Action = "none" // only tell the chat
+	ActionMute Action = "mute"
+	ActionKick Action = "kick"
+	ActionBan  Action = "ban"
+)
+
+// ParseAction returns the action with the given name
+func ParseAction(name string) (Action, bool) {
+	action := Action(strings.ToLower(name))
+	switch action {
+	case ActionNone, ActionMute, ActionKick, ActionBan:
+		return action, true
+	}
+	return "", false
+}
+
+// ApplyAction mutes, kicks or bans a user in a chat
+func (b *TeleBot) ApplyAction(chatID int64, userID int64, action Action) error {
+	switch action {
+	case ActionMute:
+		return b.restrictMember(chatID, userID, 0)
+	case ActionKick:
+		return b.kickMember(chatID, userID)
+	case ActionBan:
+		_, err := b.API.Request(tgbotapi.BanChatMemberConfig{
+			ChatMemberConfig: tgbotapi.ChatMemberConfig{ChatID: chatID, UserID: userID},
+		})
+		return err
+	}
+	return nil
+}
+
+// Names of the rules a message can break
+const (
+	RuleFilterWord  = "filter_word"
+	RuleBannedImage = "banned_image"
+	RuleDocument    = "document"
+	RuleSticker     = "sticker"
+	RuleLockdown    = "lockdown"
+)
+
+// RuleJoinScreening is recorded for new members stopped by their profile, it is not a message rule
+const RuleJoinScreening = "join_screening"
+
+// Rules lists the rules a message can break
+var Rules = []string{RuleFilterWord, RuleBannedImage, RuleDocument, RuleSticker, RuleLockdown}
+
+// Moderate handles a message that broke a rule: it goes to the review queue if the chat sends this rule there,
+// otherwise it is removed with the given notice
+func (b *TeleBot) Moderate(message *tgbotapi.Message, rule string, notice string) {
+	// Users whose message waits for review are not punished before the decision, see HandleReviewDecision
+	if b.ReviewsRule(message.Chat.ID, rule) {
+		b.SendToReview(message, rule)
+		b.RecordFlagged(message.Chat.ID)
+		return
+	}
+
+	b.RemoveMessage(message, notice)
+	if message.From != nil {
+		text := message.Text
+		if text == "" {
+			text = message.Caption
+		}
+		b.AddStrike(message.Chat, message.From, rule, b.ChatSettings(message.Chat.ID).RuleAction(rule), text)
+	}
+}
+
+// ModerationEvent records a rule that hit a user, so they can appeal it
+type ModerationEvent struct {
+	ID        int64
+	ChatID    int64
+	ChatTitle string
+	UserID    int64
+	Rule      string
+	Action    Action // ActionNone when only the message was removed
+	Text      string // the removed message, or the profile of a screened member
+	CreatedAt time.Time
+}
+
+// AddStrike records a removed message of a user, applies the action configured for the rule
+// and mutes them once they reach the chat's strike limit
+func (b *TeleBot) AddStrike(chat *tgbotapi.Chat, user *tgbotapi.User, rule string, action Action, text string) {
+	if action != ActionNone {
+		if err := b.ApplyAction(chat.ID, user.ID, action); err != nil {
+			log.Println("Error applying rule action:", err)
+			action = ActionNone
+		}
+	}
+
+	strikes, err := b.DB.AddStrike(chat.ID, user.ID)
+	if err != nil {
+		log.Println("Error storing strike:", err)
+	}
+
+	maxStrikes := b.ChatSettings(chat.ID).MaxStrikes
+	if err == nil && action == ActionNone && maxStrikes > 0 && strikes >= maxStrikes {
+		if err := b.ApplyAction(chat.ID, user.ID, ActionMute); err != nil {
+			log.Println("Error muting user:", err)
+		} else {
+			action = ActionMute
+			reply := fmt.Sprintf("%s was muted after %d strikes. Send /appeal to me in a private chat to appeal.", user.String(), strikes)
+			b.API.Send(tgbotapi.NewMessage(chat.ID, reply))
+		}
+	}
+
+	b.RecordModeration(chat, user, rule, action, text)
+}
+
+// RecordModeration stores a moderation event
+func (b *TeleBot) RecordModeration(chat *tgbotapi.Chat, user *tgbotapi.User, rule string, action Action, text string) {
+	event := ModerationEvent{
+		ChatID:    chat.ID,
+		ChatTitle: chat.Title,
+		UserID:    user.ID,
+		Rule:      rule,
+		Action:    action,
+		Text:      text,
+		CreatedAt: time.Now(),
+	}
+	if _, err := b.DB.RecordModeration(event); err != nil {
+		log.Println("Error storing moderation event:", err)
+	}
+}
+
+// RemoveMessage deletes a message from its chat and, if notice is not empty, tells the chat why.
+// Removals with a notice are rule violations and count towards raid detection.
+func (b *TeleBot) RemoveMessage(message *tgbotapi.Message, notice string) {
+	deleteConfig := tgbotapi.NewDeleteMessage(message.Chat.ID, message.MessageID)
+	if _, err := b.API.Request(deleteConfig); err != nil {
+		log.Println("Error deleting message:", err)
+	}
+
+	if notice == "" {
+		return
+	}
+	b.RecordFlagged(message.Chat.ID)
+
+	// Quiet chats remove messages without telling why
+	if !b.ChatSettings(message.Chat.ID).Verbose {
+		return
+	}
+
+	reply := notice
+	if message.From != nil {
+		reply = fmt.Sprintf("%s: %s", message.From.String(), notice)
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, reply)
+	b.API.Send(msg)
+}
+
+// IsChatAdmin reports whether the user is the creator or an administrator of the chat.
+// Everyone is considered an admin of their own private chat with the bot.
+func (b *TeleBot) IsChatAdmin(chatID int64, userID int64) bool {
+	if chatID == userID {
+		return true
+	}
+
+	admins, err := b.chatAdmins(chatID)
+	if err != nil {
+		log.Println("Error fetching chat administrators:", err)
+		return false
+	}
+	return admins[userID]
+}
+
+// reply sends a text message as a reply to the given message
+func (b *TeleBot) reply(message *tgbotapi.Message, text string) {
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyToMessageID = message.MessageID
+	b.API.Send(msg)
+}
+
+// CheckRules runs the chat rules on a message and returns true if the message was removed
+func (b *TeleBot) CheckRules(update tgbotapi.Update) bool {
+	return b.CheckLockdown(update) || b.CheckPhoto(update) || b.CheckDocument(update) || b.CheckSticker(update)
+}
+
+// restrictMember stops a member from sending anything until the given Unix time (0 means forever)
+func (b *TeleBot) restrictMember(chatID int64, userID int64, untilDate int64) error {
+	_, err := b.API.Request(tgbotapi.RestrictChatMemberConfig{
+		ChatMemberConfig: tgbotapi.ChatMemberConfig{ChatID: chatID, UserID: userID},
+		UntilDate:        untilDate,
+		Permissions:      &tgbotapi.ChatPermissions{},
+	})
+	return err
+}
+
+// unrestrictMember gives a member back the default permissions of the chat
+func (b *TeleBot) unrestrictMember(chatID int64, userID int64) error {
+	_, err := b.API.Request(tgbotapi.RestrictChatMemberConfig{
+		ChatMemberConfig: tgbotapi.ChatMemberConfig{ChatID: chatID, UserID: userID},
+		Permissions:      b.chatPermissions(chatID),
+	})
+	return err
+}
+
+// chatPermissions returns the default member permissions of a chat
+func (b *TeleBot) chatPermissions(chatID int64) *tgbotapi.ChatPermissions {
+	chat, err := b.API.GetChat(tgbotapi.ChatInfoConfig{ChatConfig: tgbotapi.ChatConfig{ChatID: chatID}})
+	if err == nil && chat.Permissions != nil {
+		return chat.Permissions
+	}
+	if err != nil {
+		log.Println("Error fetching chat:", err)
+	}
+	return &tgbotapi.ChatPermissions{
+		CanSendMessages:       true,
+		CanSendMediaMessages:  true,
+		CanSendPolls:          true,
+		CanSendOtherMessages:  true,
+		CanAddWebPagePreviews: true,
+		CanInviteUsers:        true,
+	}
+}
+
+// kickMember removes a member from the chat without banning them for good
+func (b *TeleBot) kickMember(chatID int64, userID int64) error {
+	member := tgbotapi.ChatMemberConfig{ChatID: chatID, UserID: userID}
+	if _, err := b.API.Request(tgbotapi.BanChatMemberConfig{ChatMemberConfig: member}); err != nil {
+		return err
+	}
+	// Unbanning right away lets them join again later
+	_, err := b.API.Request(tgbotapi.UnbanChatMemberConfig{ChatMemberConfig: member, OnlyIfBanned: true})
+	return err
+}
@@ -0,0 +1,271 @@
+package structs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// lockdownCheckInterval is how often lockdowns are checked for the end of their cool-down
+const lockdownCheckInterval = 30 * time.Second
+
+// RaidSettings configures raid detection in a chat
+type RaidSettings struct {
+	Enabled    bool `json:"enabled"`
+	MaxJoins   int  `json:"max_joins"`   // joins within the window that trigger a lockdown
+	MaxFlagged int  `json:"max_flagged"` // removed messages within the window that trigger a lockdown
+	Window     int  `json:"window"`      // seconds
+	CoolDown   int  `json:"cool_down"`   // seconds before a lockdown is lifted automatically
+}
+
+// Lockdown is a chat locked because of a raid
+type Lockdown struct {
+	ChatID      int64
+	Until       time.Time
+	Permissions tgbotapi.ChatPermissions // the permissions to restore when the lockdown ends
+}
+
+// lockdownPermissions are the member permissions during a lockdown: text only, no links or media
+var lockdownPermissions = tgbotapi.ChatPermissions{CanSendMessages: true}
+
+// RecordJoin counts a join towards raid detection
+func (b *TeleBot) RecordJoin(chatID int64) {
+	settings := b.ChatSettings(chatID).Raid
+	if !settings.Enabled || settings.MaxJoins <= 0 {
+		return
+	}
+	window := time.Duration(settings.Window) * time.Second
+	if b.limiter.Hit(fmt.Sprintf("raid-joins:%d", chatID), window) > settings.MaxJoins {
+		b.StartLockdown(chatID, fmt.Sprintf("more than %d members joined within %d seconds", settings.MaxJoins, settings.Window))
+	}
+}
+
+// RecordFlagged counts a removed message towards raid detection
+func (b *TeleBot) RecordFlagged(chatID int64) {
+	settings := b.ChatSettings(chatID).Raid
+	if !settings.Enabled || settings.MaxFlagged <= 0 {
+		return
+	}
+	window := time.Duration(settings.Window) * time.Second
+	if b.limiter.Hit(fmt.Sprintf("raid-flagged:%d", chatID), window) > settings.MaxFlagged {
+		b.StartLockdown(chatID, fmt.Sprintf("more than %d messages were removed within %d seconds", settings.MaxFlagged, settings.Window))
+	}
+}
+
+// StartLockdown restricts the permissions of a chat and asks the admins whether to lift it
+func (b *TeleBot) StartLockdown(chatID int64, reason string) {
+	existing, err := b.DB.GetLockdown(chatID)
+	if err != nil {
+		log.Println("Error loading lockdown:", err)
+		return
+	}
+	if existing != nil {
+		return
+	}
+
+	settings := b.ChatSettings(chatID).Raid
+	lockdown := Lockdown{
+		ChatID:      chatID,
+		Until:       time.Now().Add(time.Duration(settings.CoolDown) * time.Second),
+		Permissions: *b.chatPermissions(chatID),
+	}
+	// Store the lockdown first so the old permissions are never lost
+	if err := b.DB.SaveLockdown(lockdown); err != nil {
+		log.Println("Error storing lockdown:", err)
+		return
+	}
+
+	if err := b.setChatPermissions(chatID, lockdownPermissions); err != nil {
+		log.Println("Error locking chat:", err)
+	}
+
+	reply := fmt.Sprintf("🚨 Raid detected: %s.\nThe chat is in lockdown until %s: new members are muted and links are removed.",
+		reason, lockdown.Until.Format("15:04"))
+	msg := tgbotapi.NewMessage(chatID, reply)
+	// Without the button the lockdown is still lifted on time or with /raid lift
+	if lift, err := b.callbackButton("Lift lockdown", chatID, adminButtonTTL, CallbackLockdownLift); err != nil {
+		log.Println("Error encoding callback data:", err)
+	} else {
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(lift))
+	}
+	b.API.Send(msg)
+}
+
+// EndLockdown restores the permissions a chat had before its lockdown
+func (b *TeleBot) EndLockdown(lockdown Lockdown) {
+	if err := b.setChatPermissions(lockdown.ChatID, lockdown.Permissions); err != nil {
+		log.Println("Error lifting lockdown:", err)
+		return
+	}
+	if err := b.DB.DeleteLockdown(lockdown.ChatID); err != nil {
+		log.Println("Error deleting lockdown:", err)
+	}
+
+	msg := tgbotapi.NewMessage(lockdown.ChatID, "The lockdown is over.")
+	b.API.Send(msg)
+}
+
+// ExpireLockdowns lifts lockdowns whose cool-down is over, including the ones that ended while the bot was down
+func (b *TeleBot) ExpireLockdowns(ctx context.Context) {
+	for {
+		lockdowns, err := b.DB.ExpiredLockdowns(time.Now())
+		if err != nil {
+			log.Println("Error loading expired lockdowns:", err)
+		}
+		for _, lockdown := range lockdowns {
+			b.EndLockdown(lockdown)
+		}
+		if !sleepContext(ctx, lockdownCheckInterval) {
+			return
+		}
+	}
+}
+
+// CheckLockdown mutes new members and removes links while a chat is in lockdown.
+// It returns true when the message was removed.
+func (b *TeleBot) CheckLockdown(update tgbotapi.Update) bool {
+	message := update.Message
+	if !hasLink(message) {
+		return false
+	}
+
+	lockdown, err := b.DB.GetLockdown(message.Chat.ID)
+	if err != nil {
+		log.Println("Error loading lockdown:", err)
+		return false
+	}
+	if lockdown == nil {
+		return false
+	}
+
+	b.Moderate(message, RuleLockdown, "Links are not allowed during the lockdown.")
+	return true
+}
+
+// RestrictDuringLockdown mutes a new member until the end of the chat's lockdown.
+// It returns true when the chat is in lockdown.
+func (b *TeleBot) RestrictDuringLockdown(chatID int64, userID int64) bool {
+	lockdown, err := b.DB.GetLockdown(chatID)
+	if err != nil {
+		log.Println("Error loading lockdown:", err)
+		return false
+	}
+	if lockdown == nil {
+		return false
+	}
+
+	if err := b.restrictMember(chatID, userID, lockdown.Until.Unix()); err != nil {
+		log.Println("Error restricting new member:", err)
+	}
+	return true
+}
+
+// HandleLockdownLift lifts a lockdown when an admin presses its button
+func (b *TeleBot) HandleLockdownLift(update tgbotapi.Update) {
+	query := update.CallbackQuery
+	chatID := query.Message.Chat.ID
+
+	if !b.HasRole(chatID, query.From.ID, RoleModerator) {
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "Only moderators can lift the lockdown."))
+		return
+	}
+
+	lockdown, err := b.DB.GetLockdown(chatID)
+	if err != nil {
+		log.Println("Error loading lockdown:", err)
+		return
+	}
+	if lockdown == nil {
+		b.API.Request(tgbotapi.NewCallback(query.ID, "The lockdown is already over."))
+		return
+	}
+
+	b.API.Request(tgbotapi.NewCallback(query.ID, "Lifting the lockdown."))
+	b.EndLockdown(*lockdown)
+}
+
+// Raid shows and changes the raid detection settings of a chat
+func (b *TeleBot) Raid(update tgbotapi.Update) {
+	message := update.Message
+
+	usage := "Usage: /raid on|off, /raid joins|flagged|window|cooldown <number>, /raid lift"
+	settings := b.ChatSettings(message.Chat.ID)
+	raid := settings.Raid
+	args := strings.Fields(message.CommandArguments())
+
+	if len(args) == 0 {
+		reply := fmt.Sprintf("Raid detection: %t\nLockdown after %d joins or %d removed messages within %d seconds, lifted after %d seconds.\n\n%s",
+			raid.Enabled, raid.MaxJoins, raid.MaxFlagged, raid.Window, raid.CoolDown, usage)
+		b.reply(message, reply)
+		return
+	}
+
+	switch args[0] {
+	case "on":
+		raid.Enabled = true
+	case "off":
+		raid.Enabled = false
+	case "lift":
+		lockdown, err := b.DB.GetLockdown(message.Chat.ID)
+		if err != nil || lockdown == nil {
+			b.reply(message, "The chat is not in lockdown.")
+			return
+		}
+		b.EndLockdown(*lockdown)
+		return
+	case "joins", "flagged", "window", "cooldown":
+		if len(args) != 2 {
+			b.reply(message, usage)
+			return
+		}
+		value, err := strconv.Atoi(args[1])
+		if err != nil || value < 0 {
+			b.reply(message, "Please provide a positive number.")
+			return
+		}
+		switch args[0] {
+		case "joins":
+			raid.MaxJoins = value
+		case "flagged":
+			raid.MaxFlagged = value
+		case "window":
+			raid.Window = value
+		case "cooldown":
+			raid.CoolDown = value
+		}
+	default:
+		b.reply(message, usage)
+		return
+	}
+
+	settings.Raid = raid
+	if err := b.SaveChatSettings(message.Chat.ID, settings); err != nil {
+		b.reply(message, "Could not save the raid settings. Please try again.")
+		return
+	}
+	b.reply(message, "Raid settings updated.")
+}
+
+// hasLink reports whether a message contains a URL or a text link
+func hasLink(message *tgbotapi.Message) bool {
+	for _, entity := range append(message.Entities, message.CaptionEntities...) {
+		if entity.Type == "url" || entity.Type == "text_link" {
+			return true
+		}
+	}
+	return false
+}
+
+// setChatPermissions changes the default member permissions of a chat
+func (b *TeleBot) setChatPermissions(chatID int64, permissions tgbotapi.ChatPermissions) error {
+	_, err := b.API.Request(tgbotapi.SetChatPermissionsConfig{
+		ChatConfig:  tgbotapi.ChatConfig{ChatID: chatID},
+		Permissions: &permissions,
+	})
+	return err
+}
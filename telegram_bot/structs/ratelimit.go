@@ -0,0 +1,60 @@
+package structs
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiterSweep is how often the keys without recent events are forgotten
+const rateLimiterSweep = time.Minute
+
+// rateLimiter counts events per key over a sliding time window
+type rateLimiter struct {
+	mu      sync.Mutex
+	events  map[string][]time.Time
+	windows map[string]time.Duration // last window asked for each key
+	swept   time.Time
+}
+
+// Allow records an event for the key and reports whether it stays within limit events per window
+func (r *rateLimiter) Allow(key string, limit int, window time.Duration) bool {
+	return r.Hit(key, window) <= limit
+}
+
+// Hit records an event for the key and returns how many events happened within the window, this one included
+func (r *rateLimiter) Hit(key string, window time.Duration) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.events == nil {
+		r.events = make(map[string][]time.Time)
+		r.windows = make(map[string]time.Duration)
+	}
+
+	now := time.Now()
+	recent := r.events[key][:0]
+	for _, t := range r.events[key] {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	r.events[key] = recent
+	r.windows[key] = window
+
+	if now.Sub(r.swept) >= rateLimiterSweep {
+		r.sweep(now)
+	}
+	return len(recent)
+}
+
+// sweep forgets the keys whose events all fell out of their window, the caller holds the lock
+func (r *rateLimiter) sweep(now time.Time) {
+	for key, events := range r.events {
+		if len(events) == 0 || now.Sub(events[len(events)-1]) >= r.windows[key] {
+			delete(r.events, key)
+			delete(r.windows, key)
+		}
+	}
+	r.swept = now
+}
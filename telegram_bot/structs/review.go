@@ -0,0 +1,321 @@
+package structs
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Statuses of a review item
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+	ReviewBanned   = "banned"
+)
+
+// ReviewSettings configures the review queue of a chat
+type ReviewSettings struct {
+	ChatID int64    `json:"chat_id"` // the admin chat that receives messages to review and appeals
+	Rules  []string `json:"rules"`   // rules whose matches go to the review queue instead of being removed
+}
+
+// ReviewItem is a message waiting for, or having received, an admin decision
+type ReviewItem struct {
+	ID           int64
+	ChatID       int64
+	MessageID    int
+	UserID       int64
+	UserName     string
+	Rule         string
+	Text         string // text or caption of the original message
+	ReviewChatID int64
+	CopyID       int // the copy of the message in the review chat
+	Status       string
+	DecidedBy    int64
+}
+
+// ReviewStat counts the decisions taken on the matches of a rule
+type ReviewStat struct {
+	Rule     string
+	Approved int
+	Rejected int
+	Banned   int
+	Pending  int
+}
+
+// ReviewsRule reports whether matches of a rule go to the chat's review queue
+func (b *TeleBot) ReviewsRule(chatID int64, rule string) bool {
+	review := b.ChatSettings(chatID).Review
+	if review.ChatID == 0 {
+		return false
+	}
+	for _, reviewed := range review.Rules {
+		if reviewed == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// SendToReview copies a message into the review chat with Approve / Reject / Ban buttons and removes it from its chat
+func (b *TeleBot) SendToReview(message *tgbotapi.Message, rule string) {
+	reviewChatID := b.ChatSettings(message.Chat.ID).Review.ChatID
+
+	copied, err := b.API.CopyMessage(tgbotapi.NewCopyMessage(reviewChatID, message.Chat.ID, message.MessageID))
+	if err != nil {
+		// Without a copy there is nothing to review, fall back to removing the message
+		log.Println("Error copying message for review:", err)
+		b.RemoveMessage(message, "")
+		return
+	}
+
+	item := ReviewItem{
+		ChatID:       message.Chat.ID,
+		MessageID:    message.MessageID,
+		Rule:         rule,
+		Text:         message.Text,
+		ReviewChatID: reviewChatID,
+		CopyID:       copied.MessageID,
+		Status:       ReviewPending,
+	}
+	if message.Text == "" {
+		item.Text = message.Caption
+	}
+	if message.From != nil {
+		item.UserID = message.From.ID
+		item.UserName = message.From.String()
+	}
+
+	item.ID, err = b.DB.CreateReviewItem(item)
+	if err != nil {
+		// A copy without a review item cannot be decided on, remove both like an unreviewed rule
+		log.Println("Error storing review item:", err)
+		if _, err := b.API.Request(tgbotapi.NewDeleteMessage(reviewChatID, copied.MessageID)); err != nil {
+			log.Println("Error deleting review copy:", err)
+		}
+		b.RemoveMessage(message, "")
+		return
+	}
+
+	card := fmt.Sprintf("Review #%d\nChat: %s\nAuthor: %s (%d)\nRule: %s", item.ID, message.Chat.Title, item.UserName, item.UserID, rule)
+	id := strconv.FormatInt(item.ID, 10)
+	var row []tgbotapi.InlineKeyboardButton
+	for _, decision := range []struct{ label, status string }{
+		{"Approve", ReviewApproved},
+		{"Reject", ReviewRejected},
+		{"Ban", ReviewBanned},
+	} {
+		button, err := b.callbackButton(decision.label, reviewChatID, reviewButtonTTL, CallbackReview, decision.status, id)
+		if err != nil {
+			log.Println("Error encoding callback data:", err)
+			continue
+		}
+		row = append(row, button)
+	}
+	msg := tgbotapi.NewMessage(reviewChatID, card)
+	msg.ReplyToMessageID = copied.MessageID
+	if len(row) > 0 {
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(row)
+	}
+	if _, err := b.API.Send(msg); err != nil {
+		log.Println("Error sending review card:", err)
+	}
+
+	b.RemoveMessage(message, "")
+}
+
+// HandleReviewDecision applies the decision of an admin pressing a button on a review card,
+// args are the decision and the review item ID
+func (b *TeleBot) HandleReviewDecision(update tgbotapi.Update, args []string) {
+	query := update.CallbackQuery
+
+	if len(args) != 2 {
+		return
+	}
+	decision := args[0]
+	id, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	item, err := b.DB.GetReviewItem(id)
+	if err != nil || item == nil {
+		log.Println("Error loading review item:", err)
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "This review item no longer exists."))
+		return
+	}
+	if !b.HasRole(item.ReviewChatID, query.From.ID, RoleModerator) {
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "Only moderators of the review chat can decide."))
+		return
+	}
+	if item.Status != ReviewPending {
+		b.API.Request(tgbotapi.NewCallback(query.ID, "This message was already "+item.Status+"."))
+		return
+	}
+
+	switch decision {
+	case ReviewApproved:
+		b.repostReviewed(*item)
+	case ReviewBanned:
+		b.strikeReviewed(*item, ActionBan)
+	case ReviewRejected:
+		b.strikeReviewed(*item, b.ChatSettings(item.ChatID).RuleAction(item.Rule))
+	default:
+		return
+	}
+
+	if err := b.DB.DecideReviewItem(item.ID, decision, query.From.ID); err != nil {
+		log.Println("Error storing review decision:", err)
+	}
+	b.API.Request(tgbotapi.NewCallback(query.ID, "Message "+decision+"."))
+
+	// Replace the buttons with the decision
+	text := fmt.Sprintf("%s\n\nDecision: %s by %s", query.Message.Text, decision, query.From.String())
+	b.API.Request(tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text))
+}
+
+// strikeReviewed adds the strike of a rejected message and applies the action, like for a message removed without review.
+// Messages only get their strike once they are rejected, so approved ones leave no trace.
+func (b *TeleBot) strikeReviewed(item ReviewItem, action Action) {
+	if item.UserID == 0 {
+		return
+	}
+	chat := &tgbotapi.Chat{ID: item.ChatID, Title: b.chatTitle(item.ChatID)}
+	user := &tgbotapi.User{ID: item.UserID, FirstName: item.UserName}
+	b.AddStrike(chat, user, item.Rule, action, item.Text)
+}
+
+// repostReviewed posts an approved message back into its chat, attributed to its author
+func (b *TeleBot) repostReviewed(item ReviewItem) {
+	attribution := fmt.Sprintf("%s wrote:\n%s", item.UserName, item.Text)
+
+	var err error
+	if item.Text != "" {
+		_, err = b.API.Send(tgbotapi.NewMessage(item.ChatID, attribution))
+	} else {
+		copyConfig := tgbotapi.NewCopyMessage(item.ChatID, item.ReviewChatID, item.CopyID)
+		copyConfig.Caption = attribution
+		_, err = b.API.CopyMessage(copyConfig)
+	}
+	if err != nil {
+		log.Println("Error reposting approved message:", err)
+	}
+}
+
+// Review shows and changes the review queue settings of a chat
+func (b *TeleBot) Review(update tgbotapi.Update) {
+	message := update.Message
+
+	usage := "Usage: /review chat <admin chat ID>, /review add|remove <rule>, /review off, /review stats\nRules: " + strings.Join(Rules, ", ")
+	settings := b.ChatSettings(message.Chat.ID)
+	review := settings.Review
+	args := strings.Fields(message.CommandArguments())
+
+	if len(args) == 0 {
+		reply := fmt.Sprintf("Review chat: %d\nReviewed rules: %s\n\n%s", review.ChatID, strings.Join(review.Rules, ", "), usage)
+		b.reply(message, reply)
+		return
+	}
+
+	switch {
+	case args[0] == "stats":
+		b.reviewStats(message)
+		return
+	case args[0] == "off":
+		review = ReviewSettings{}
+	case args[0] == "chat" && len(args) == 2:
+		chatID, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			b.reply(message, "Please provide the numeric ID of the admin chat.")
+			return
+		}
+		if err := b.checkReviewChat(chatID, message.From.ID); err != nil {
+			b.reply(message, err.Error())
+			return
+		}
+		review.ChatID = chatID
+	case args[0] == "add" && len(args) == 2:
+		if !isRule(args[1]) {
+			b.reply(message, usage)
+			return
+		}
+		if !containsString(review.Rules, args[1]) {
+			review.Rules = append(append([]string(nil), review.Rules...), args[1])
+		}
+	case args[0] == "remove" && len(args) == 2:
+		var rules []string
+		for _, rule := range review.Rules {
+			if rule != args[1] {
+				rules = append(rules, rule)
+			}
+		}
+		review.Rules = rules
+	default:
+		b.reply(message, usage)
+		return
+	}
+
+	settings.Review = review
+	if err := b.SaveChatSettings(message.Chat.ID, settings); err != nil {
+		b.reply(message, "Could not save the review settings. Please try again.")
+		return
+	}
+	b.reply(message, "Review queue updated.")
+}
+
+// checkReviewChat makes sure a chat can receive the review queue of a user's group:
+// it is the user's own private chat, or a group where both the bot and the user are, the user as an admin
+func (b *TeleBot) checkReviewChat(chatID int64, userID int64) error {
+	if chatID == userID {
+		return nil
+	}
+	self, err := b.API.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: b.API.Self.ID},
+	})
+	if err != nil || self.HasLeft() || self.WasKicked() {
+		return errors.New("I am not a member of that chat. Add me to it first.")
+	}
+	admin, err := b.isGroupAdmin(chatID, userID)
+	if err != nil {
+		log.Println("Error checking chat member:", err)
+		return errors.New("Could not check your status in that chat. Please try again.")
+	}
+	if !admin {
+		return errors.New("Only admins of the review chat can send the review queue there.")
+	}
+	return nil
+}
+
+// reviewStats replies with the decisions taken on each rule, to help tune the rules
+func (b *TeleBot) reviewStats(message *tgbotapi.Message) {
+	stats, err := b.DB.ReviewStats(message.Chat.ID)
+	if err != nil {
+		b.reply(message, "Could not load the review statistics. Please try again.")
+		return
+	}
+	if len(stats) == 0 {
+		b.reply(message, "No messages were reviewed yet.")
+		return
+	}
+
+	reply := "Review decisions per rule:\n"
+	for _, stat := range stats {
+		reply += fmt.Sprintf("%s: %d approved, %d rejected, %d banned, %d pending\n", stat.Rule, stat.Approved, stat.Rejected, stat.Banned, stat.Pending)
+	}
+	b.reply(message, reply)
+}
+
+// isRule reports whether name is a known rule name
+func isRule(name string) bool {
+	for _, rule := range Rules {
+		if rule == name {
+			return true
+		}
+	}
+	return false
+}
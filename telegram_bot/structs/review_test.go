@@ -0,0 +1,18 @@
+package structs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReviewAddOnce(t *testing.T) {
+	bot, _ := newFakeBot(t)
+
+	// Rules can be added before the review chat is set, each one only once
+	for i := 0; i < 2; i++ {
+		bot.Review(commandMessage("/review add "+RuleFilterWord, ChatSupergroup))
+	}
+	if rules := bot.ChatSettings(-100123).Review.Rules; !reflect.DeepEqual(rules, []string{RuleFilterWord}) {
+		t.Errorf("reviewed rules = %v, want [%s]", rules, RuleFilterWord)
+	}
+}
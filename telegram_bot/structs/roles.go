@@ -0,0 +1,113 @@
+package structs
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Role is the level of trust of a user in a chat, higher roles include the lower ones
+type Role int
+
+const (
+	RoleUser Role = iota
+	RoleModerator
+	RoleAdmin // creator or administrator of the chat
+	RoleOwner // the operator of the bot, set from configuration
+)
+
+// String returns the name of the role as stored in the database
+func (r Role) String() string {
+	switch r {
+	case RoleModerator:
+		return "moderator"
+	case RoleAdmin:
+		return "admin"
+	case RoleOwner:
+		return "owner"
+	}
+	return "user"
+}
+
+// ParseRole returns the role with the given name
+func ParseRole(name string) Role {
+	for _, role := range []Role{RoleModerator, RoleAdmin, RoleOwner} {
+		if role.String() == name {
+			return role
+		}
+	}
+	return RoleUser
+}
+
+// UserRole returns the role of a user in a chat
+func (b *TeleBot) UserRole(chatID int64, userID int64) Role {
+	if b.OwnerID != 0 && userID == b.OwnerID {
+		return RoleOwner
+	}
+	if b.IsChatAdmin(chatID, userID) {
+		return RoleAdmin
+	}
+
+	role, err := b.DB.GetRole(chatID, userID)
+	if err != nil {
+		log.Println("Error loading role:", err)
+		return RoleUser
+	}
+	return role
+}
+
+// HasRole reports whether a user has at least the given role in a chat
+func (b *TeleBot) HasRole(chatID int64, userID int64, role Role) bool {
+	if role == RoleUser {
+		return true
+	}
+	return b.UserRole(chatID, userID) >= role
+}
+
+// commandTarget returns the user a moderation command is about: the author of the replied message or a numeric ID argument
+func commandTarget(message *tgbotapi.Message, args []string) (int64, bool) {
+	if message.ReplyToMessage != nil && message.ReplyToMessage.From != nil {
+		return message.ReplyToMessage.From.ID, true
+	}
+	if len(args) == 1 {
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		return id, err == nil
+	}
+	return 0, false
+}
+
+// Mod makes a user a moderator of the chat
+func (b *TeleBot) Mod(update tgbotapi.Update) {
+	message := update.Message
+
+	userID, ok := commandTarget(message, strings.Fields(message.CommandArguments()))
+	if !ok {
+		b.reply(message, "Reply to a message of the user with /mod, or use /mod <user ID>.")
+		return
+	}
+
+	if err := b.DB.SetRole(message.Chat.ID, userID, RoleModerator); err != nil {
+		b.reply(message, "Could not save the role. Please try again.")
+		return
+	}
+	b.reply(message, "The user is now a moderator.")
+}
+
+// Unmod removes the moderator role of a user
+func (b *TeleBot) Unmod(update tgbotapi.Update) {
+	message := update.Message
+
+	userID, ok := commandTarget(message, strings.Fields(message.CommandArguments()))
+	if !ok {
+		b.reply(message, "Reply to a message of the user with /unmod, or use /unmod <user ID>.")
+		return
+	}
+
+	if err := b.DB.DeleteRole(message.Chat.ID, userID); err != nil {
+		b.reply(message, "Could not remove the role. Please try again.")
+		return
+	}
+	b.reply(message, "The user is no longer a moderator.")
+}
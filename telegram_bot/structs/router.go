@@ -0,0 +1,255 @@
+package structs
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Chat types a command can be allowed in
+const (
+	ChatPrivate    = "private"
+	ChatGroup      = "group"
+	ChatSupergroup = "supergroup"
+)
+
+// groupChats are the chat types of commands that only make sense in groups
+var groupChats = []string{ChatGroup, ChatSupergroup}
+
+// commandRateLimit is the number of commands a user may send within commandRateWindow
+const (
+	commandRateLimit  = 5
+	commandRateWindow = 10 * time.Second
+)
+
+// HandlerFunc handles an update
+type HandlerFunc func(update tgbotapi.Update)
+
+// Middleware wraps the handler of a command, it may run code around it or not call it at all
+type Middleware func(command Command, next HandlerFunc) HandlerFunc
+
+// Command describes a bot command
+type Command struct {
+	Name        string
+	Description string
+	Role        Role     // minimum role of the sender
+	ChatTypes   []string // chat types the command may be used in, empty means all
+	Connectable bool     // in a private chat, the command applies to the group the sender is connected to
+	Handler     HandlerFunc
+}
+
+// AllowedIn reports whether the command may be used in a chat of the given type
+func (c Command) AllowedIn(chatType string) bool {
+	if len(c.ChatTypes) == 0 {
+		return true
+	}
+	for _, allowed := range c.ChatTypes {
+		if allowed == chatType {
+			return true
+		}
+	}
+	return false
+}
+
+// Router dispatches commands to their handlers through a chain of middleware
+type Router struct {
+	commands   []Command
+	middleware []Middleware
+}
+
+// Register adds a command to the router
+func (r *Router) Register(command Command) {
+	r.commands = append(r.commands, command)
+}
+
+// Use adds a middleware, the first one added runs first
+func (r *Router) Use(middleware Middleware) {
+	r.middleware = append(r.middleware, middleware)
+}
+
+// Commands returns the registered commands in registration order
+func (r *Router) Commands() []Command {
+	return r.commands
+}
+
+// Lookup returns the command with the given name
+func (r *Router) Lookup(name string) (Command, bool) {
+	for _, command := range r.commands {
+		if command.Name == name {
+			return command, true
+		}
+	}
+	return Command{}, false
+}
+
+// Dispatch runs the handler of a command wrapped in the middleware.
+// It returns false if no command with that name is registered.
+func (r *Router) Dispatch(name string, update tgbotapi.Update) bool {
+	command, ok := r.Lookup(name)
+	if !ok {
+		return false
+	}
+
+	handler := command.Handler
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		handler = r.middleware[i](command, handler)
+	}
+	handler(update)
+	return true
+}
+
+// HandleCommand routes a command message, ignoring commands meant for other bots
+func (b *TeleBot) HandleCommand(update tgbotapi.Update) {
+	message := update.Message
+	if message.From == nil {
+		return
+	}
+
+	// In groups, /command@otherbot is not for us
+	if command := message.CommandWithAt(); strings.Contains(command, "@") && !strings.HasSuffix(command, "@"+b.API.Self.UserName) {
+		return
+	}
+
+	// Groups may run several bots, only commands meant for this one are answered as unknown
+	addressed := message.Chat.Type == ChatPrivate || strings.Contains(message.CommandWithAt(), "@")
+	if !b.router.Dispatch(message.Command(), update) && addressed {
+		b.reply(message, fmt.Sprintf("Unknown command /%s. Use /help to see the available commands.", message.Command()))
+	}
+}
+
+// RecoverPanic keeps the bot running when a handler panics
+func (b *TeleBot) RecoverPanic(command Command, next HandlerFunc) HandlerFunc {
+	return func(update tgbotapi.Update) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Panic in /%s: %v\n%s", command.Name, r, debug.Stack())
+				b.reply(update.Message, "Something went wrong. Please try again.")
+			}
+		}()
+		next(update)
+	}
+}
+
+// recoverUpdate keeps the bot running when handling an update other than a command panics,
+// it must be deferred
+func recoverUpdate(kind string) {
+	if r := recover(); r != nil {
+		log.Printf("Panic handling %s: %v\n%s", kind, r, debug.Stack())
+	}
+}
+
+// LogCommand logs every command with its sender and how long it took
+func (b *TeleBot) LogCommand(command Command, next HandlerFunc) HandlerFunc {
+	return func(update tgbotapi.Update) {
+		start := time.Now()
+		next(update)
+		log.Printf("/%s from %d in chat %d took %s", command.Name, update.Message.From.ID, update.Message.Chat.ID, time.Since(start))
+	}
+}
+
+// RateLimitCommands stops users from sending more than commandRateLimit commands within commandRateWindow
+func (b *TeleBot) RateLimitCommands(command Command, next HandlerFunc) HandlerFunc {
+	return func(update tgbotapi.Update) {
+		key := fmt.Sprintf("commands:%d", update.Message.From.ID)
+		hits := b.limiter.Hit(key, commandRateWindow)
+		if hits > commandRateLimit {
+			// Only warn once, further commands are dropped silently
+			if hits == commandRateLimit+1 {
+				b.reply(update.Message, "You are sending commands too fast. Please wait a few seconds.")
+			}
+			return
+		}
+		next(update)
+	}
+}
+
+// CheckChatType refuses commands used in a chat type they are not meant for.
+// Group commands that are connectable may be used in a private chat connected to a group.
+func (b *TeleBot) CheckChatType(command Command, next HandlerFunc) HandlerFunc {
+	return func(update tgbotapi.Update) {
+		if !command.AllowedIn(update.Message.Chat.Type) {
+			if _, ok := b.connectedChat(update.Message); ok && command.Connectable {
+				next(update)
+				return
+			}
+			if command.Connectable {
+				b.reply(update.Message, fmt.Sprintf("Use /%s in a group, or /connect to a group to use it here.", command.Name))
+			} else if command.AllowedIn(ChatPrivate) {
+				b.reply(update.Message, fmt.Sprintf("Send /%s to me in a private chat.", command.Name))
+			} else {
+				b.reply(update.Message, fmt.Sprintf("/%s can only be used in groups.", command.Name))
+			}
+			return
+		}
+		next(update)
+	}
+}
+
+// Authorize refuses commands to senders who do not have the role they require.
+// Connectable commands require the role in the group the command applies to.
+func (b *TeleBot) Authorize(command Command, next HandlerFunc) HandlerFunc {
+	return func(update tgbotapi.Update) {
+		message := update.Message
+		chatID := message.Chat.ID
+		if command.Connectable {
+			chatID = b.TargetChat(message)
+		}
+		if !b.HasRole(chatID, message.From.ID, command.Role) {
+			b.reply(message, fmt.Sprintf("You need to be %s to use /%s.", command.Role, command.Name))
+			return
+		}
+		next(update)
+	}
+}
+
+// RegisterCommands publishes the command menus shown by Telegram clients.
+// Private chats and group admins see the commands they can use, group members only the public ones.
+func (b *TeleBot) RegisterCommands() {
+	menus := []struct {
+		scope   tgbotapi.BotCommandScope
+		include func(Command) bool
+	}{
+		{
+			tgbotapi.BotCommandScope{Type: "all_private_chats"},
+			func(c Command) bool { return (c.AllowedIn(ChatPrivate) || c.Connectable) && c.Role <= RoleAdmin },
+		},
+		{
+			tgbotapi.BotCommandScope{Type: "all_group_chats"},
+			func(c Command) bool { return c.AllowedIn(ChatSupergroup) && c.Role == RoleUser },
+		},
+		{
+			tgbotapi.BotCommandScope{Type: "all_chat_administrators"},
+			func(c Command) bool { return c.AllowedIn(ChatSupergroup) && c.Role <= RoleAdmin },
+		},
+	}
+
+	for _, menu := range menus {
+		var commands []tgbotapi.BotCommand
+		for _, command := range b.router.Commands() {
+			if menu.include(command) {
+				commands = append(commands, tgbotapi.BotCommand{Command: command.Name, Description: command.Description})
+			}
+		}
+		if _, err := b.API.Request(tgbotapi.NewSetMyCommandsWithScope(menu.scope, commands...)); err != nil {
+			log.Println("Error registering commands:", err)
+		}
+	}
+}
+
+// HelpText lists the commands the sender of a message can use in its chat
+func (b *TeleBot) HelpText(message *tgbotapi.Message) string {
+	role := b.UserRole(message.Chat.ID, message.From.ID)
+
+	text := "Available commands:\n"
+	for _, command := range b.router.Commands() {
+		allowed := command.AllowedIn(message.Chat.Type) || (command.Connectable && message.Chat.Type == ChatPrivate)
+		if allowed && role >= command.Role {
+			text += fmt.Sprintf("/%s - %s\n", command.Name, command.Description)
+		}
+	}
+	return text
+}
@@ -0,0 +1,48 @@
+package structs
+
+import (
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func commandMessage(text, chatType string) tgbotapi.Update {
+	command := strings.Fields(text)[0]
+	return tgbotapi.Update{Message: &tgbotapi.Message{
+		MessageID: 5,
+		From:      &tgbotapi.User{ID: 7, FirstName: "Ali"},
+		Chat:      &tgbotapi.Chat{ID: -100123, Type: chatType},
+		Text:      text,
+		Entities:  []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}},
+	}}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	tests := []struct {
+		text, chatType string
+		answered       bool
+	}{
+		{"/nosuch", ChatPrivate, true},
+		{"/nosuch", ChatSupergroup, false},
+		{"/nosuch@test_bot", ChatSupergroup, true},
+		{"/nosuch@other_bot", ChatSupergroup, false},
+	}
+	for _, test := range tests {
+		bot, api := newFakeBot(t)
+		bot.HandleCommand(commandMessage(test.text, test.chatType))
+		if answered := len(api.called("sendMessage")) > 0; answered != test.answered {
+			t.Errorf("%s in a %s chat answered = %v, want %v", test.text, test.chatType, answered, test.answered)
+		}
+	}
+}
+
+func TestHandleUpdateRecovers(t *testing.T) {
+	bot, _ := newFakeBot(t)
+	// A forged callback query without a sender makes its handler panic, the bot must keep running
+	bot.HandleUpdate(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:      "1",
+		Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: -100123}},
+		Data:    "forged",
+	}})
+}
@@ -0,0 +1,438 @@
+package structs
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// searchPrompt asks for a search and explains the query language
+const searchPrompt = "Please enter the words to search for. Each one also finds the words starting with it.\n" +
+	"You can also use \"exact phrase\", from:@username, after:2026-01-01, before:2026-02-01, " +
+	"matched:<rule or filter word> and has:link (or has:photo, has:document, ...)."
+
+// Matched words are marked in headlines with characters from the private use area, which never appear in real text
+const (
+	highlightStart = "\ue000"
+	highlightStop  = "\ue001"
+)
+
+// headlineOptions are the ts_headline options that mark the matched words and keep excerpts short
+const headlineOptions = "StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", MaxFragments=2, MaxWords=20, MinWords=5"
+
+// searchDateLayout is how after: and before: dates are written
+const searchDateLayout = "2006-01-02"
+
+// linkPattern finds links in message text, it is both a Go and a Postgres regular expression
+const linkPattern = `(https?://|www\.|t\.me/)`
+
+var linkRegexp = regexp.MustCompile("(?i)" + linkPattern)
+
+// SearchQuery is what /show looks for in the stored messages of a chat, e.g.
+// from:@ali after:2026-01-01 before:2026-02-01 matched:spam has:link "exact phrase" word
+type SearchQuery struct {
+	Terms    []string // every term must match, each one matches the words starting with it
+	Phrases  []string // every phrase must appear as is, from "quoted text"
+	Language string   // language of the chat, picks the text search configuration
+
+	FromID       int64     // from:<user ID>
+	FromUsername string    // from:@username, without the @
+	After        time.Time // after:<date>, the messages sent that day or later
+	Before       time.Time // before:<date>, the messages sent before that day
+	Matched      []string  // matched:<rule or pattern>, e.g. matched:filter_word or matched:spam
+	Has          []string  // has:link or has:<content type>, e.g. has:photo
+	Unmatched    bool      // only the messages no rule matched, for the /show menu
+}
+
+// searchOperators are the operators a query may use, in the order they are listed to users
+var searchOperators = []string{"from:", "after:", "before:", "matched:", "has:"}
+
+// searchableContent are the values of has:, besides link
+var searchableContent = []string{"photo", "animation", "document", "sticker", "video", "video_note", "voice", "audio", "contact", "location", "poll"}
+
+// HasText reports whether the query searches the text of the messages, not only filters them
+func (q SearchQuery) HasText() bool {
+	return len(q.Terms) > 0 || len(q.Phrases) > 0
+}
+
+// SearchResult is a stored message found by a search, best matches first
+type SearchResult struct {
+	StoredMessage
+	Rank     float64
+	Headline string // excerpts of the text with the matched words between highlightStart and highlightStop
+}
+
+// SearchCursor is the position of a result in the order of a search, pages start after the cursor of the previous one
+type SearchCursor struct {
+	Rank     float64
+	SentDate time.Time
+	ID       int64
+}
+
+// Cursor returns the position of the result
+func (r SearchResult) Cursor() SearchCursor {
+	return SearchCursor{Rank: r.Rank, SentDate: r.SentDate, ID: r.ID}
+}
+
+// Before reports whether a result at cursor c comes before one at cursor other
+func (c SearchCursor) Before(other SearchCursor) bool {
+	if c.Rank != other.Rank {
+		return c.Rank > other.Rank
+	}
+	if !c.SentDate.Equal(other.SentDate) {
+		return c.SentDate.After(other.SentDate)
+	}
+	return c.ID > other.ID
+}
+
+// ParseSearchQuery reads a search typed by a user. The errors explain what is wrong in a way users understand.
+func ParseSearchQuery(text string, language string) (SearchQuery, error) {
+	query := SearchQuery{Language: language}
+
+	tokens, err := searchTokens(text)
+	if err != nil {
+		return query, err
+	}
+	for _, token := range tokens {
+		if token.quoted {
+			if strings.TrimSpace(token.text) != "" {
+				query.Phrases = append(query.Phrases, token.text)
+			}
+			continue
+		}
+
+		// Links such as https://example.com are words, not operators
+		name, value, ok := strings.Cut(token.text, ":")
+		if !ok || name == "" || strings.HasPrefix(value, "//") {
+			query.Terms = append(query.Terms, token.text)
+			continue
+		}
+		operator := strings.ToLower(name) + ":"
+		if value == "" && isSearchOperator(operator) {
+			return query, fmt.Errorf("%s needs a value, e.g. %s.", operator, searchOperatorExample(operator))
+		}
+
+		switch operator {
+		case "from:":
+			if query.FromID != 0 || query.FromUsername != "" {
+				return query, errors.New("from: can only be used once.")
+			}
+			if id, err := strconv.ParseInt(value, 10, 64); err == nil {
+				query.FromID = id
+			} else {
+				query.FromUsername = strings.TrimPrefix(value, "@")
+			}
+
+		case "after:", "before:":
+			date, err := time.Parse(searchDateLayout, value)
+			if err != nil {
+				return query, fmt.Errorf("%s takes a date written like %s, not %q.", operator, searchOperatorExample(operator), value)
+			}
+			if operator == "after:" {
+				query.After = date
+			} else {
+				query.Before = date
+			}
+
+		case "matched:":
+			query.Matched = append(query.Matched, value)
+
+		case "has:":
+			value = strings.ToLower(value)
+			if value != "link" && !containsString(searchableContent, value) {
+				return query, fmt.Errorf("has: takes link or one of %s, not %q.", strings.Join(searchableContent, ", "), value)
+			}
+			query.Has = append(query.Has, value)
+
+		default:
+			return query, fmt.Errorf("Unknown operator %s. You can use %s, or put the word in quotes to search for it.",
+				operator, strings.Join(searchOperators, ", "))
+		}
+	}
+
+	if !query.HasText() && !query.Unmatched && query.FromID == 0 && query.FromUsername == "" && query.After.IsZero() && query.Before.IsZero() &&
+		len(query.Matched) == 0 && len(query.Has) == 0 {
+		return query, errors.New("Please enter at least one word or operator to search for.")
+	}
+	if !query.After.IsZero() && !query.Before.IsZero() && !query.After.Before(query.Before) {
+		return query, errors.New("after: must be a day before before:, no message can match both.")
+	}
+	return query, nil
+}
+
+// SearchQueryText validates a search typed in a conversation, so the user can correct it
+func SearchQueryText(text string) (string, error) {
+	if _, err := ParseSearchQuery(text, ""); err != nil {
+		return "", err
+	}
+	return text, nil
+}
+
+// isSearchOperator reports whether a name followed by a colon is an operator of the query language
+func isSearchOperator(operator string) bool {
+	return containsString(searchOperators, operator)
+}
+
+// searchOperatorExample shows how an operator is used
+func searchOperatorExample(operator string) string {
+	switch operator {
+	case "from:":
+		return "from:@username"
+	case "after:":
+		return "after:2026-01-01"
+	case "before:":
+		return "before:2026-02-01"
+	case "matched:":
+		return "matched:filter_word"
+	}
+	return "has:link"
+}
+
+// containsString reports whether a list contains a string
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// searchToken is a word of a query, or the text between quotes
+type searchToken struct {
+	text   string
+	quoted bool
+}
+
+// searchTokens splits a query on spaces, keeping "quoted text" together
+func searchTokens(text string) ([]searchToken, error) {
+	var tokens []searchToken
+	var current strings.Builder
+	quoted := false
+
+	flush := func() {
+		if current.Len() > 0 || quoted {
+			tokens = append(tokens, searchToken{text: current.String(), quoted: quoted})
+		}
+		current.Reset()
+	}
+	for _, r := range text {
+		switch {
+		case r == '"' || r == '“' || r == '”':
+			flush()
+			quoted = !quoted
+		case unicode.IsSpace(r) && !quoted:
+			flush()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if quoted {
+		return nil, errors.New("A quote is not closed. Put phrases between two quotes, like \"exact phrase\".")
+	}
+	flush()
+	return tokens, nil
+}
+
+// searchConfig returns the Postgres text search configuration of a chat language.
+// persian is a copy of simple: Postgres ships no Persian stemmer or stop words, so Persian words
+// are only normalized (see persian_normalize) and matched by prefix, never reduced to their stem.
+func searchConfig(language string) string {
+	if language == "fa" {
+		return "persian"
+	}
+	return "simple"
+}
+
+// tsQuery builds a to_tsquery expression matching every term as a prefix.
+// Terms are quoted, so nothing a user types is read as a tsquery operator.
+func tsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		term = strings.ReplaceAll(term, `\`, `\\`)
+		term = strings.ReplaceAll(term, "'", "''")
+		quoted[i] = "'" + term + "':*"
+	}
+	return strings.Join(quoted, " & ")
+}
+
+// persianReplacer folds the spellings of Persian text like the persian_normalize SQL function
+var persianReplacer = strings.NewReplacer(
+	"ي", "ی", "ى", "ی", "ك", "ک", "ۀ", "ه", "ة", "ه",
+	"٠", "0", "١", "1", "٢", "2", "٣", "3", "٤", "4", "٥", "5", "٦", "6", "٧", "7", "٨", "8", "٩", "9",
+	"۰", "0", "۱", "1", "۲", "2", "۳", "3", "۴", "4", "۵", "5", "۶", "6", "۷", "7", "۸", "8", "۹", "9",
+	"\u200c", "", "\u0640", "", // zero-width non-joiner and tatweel
+	"\u064b", "", "\u064c", "", "\u064d", "", "\u064e", "", "\u064f", "", "\u0650", "", "\u0651", "", "\u0652", "", // diacritics
+)
+
+// normalizeSearchText folds case and Persian spellings, for searching without Postgres
+func normalizeSearchText(text string) string {
+	return strings.ToLower(persianReplacer.Replace(text))
+}
+
+// wordSpans returns the start and end offsets, in runes, of the words of a text
+func wordSpans(runes []rune) [][2]int {
+	var spans [][2]int
+	start := -1
+	for i, r := range runes {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || r == '\u200c'
+		if inWord && start < 0 {
+			start = i
+		} else if !inWord && start >= 0 {
+			spans = append(spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, [2]int{start, len(runes)})
+	}
+	return spans
+}
+
+// searchTerms splits and normalizes the terms of a query like the words of the searched text
+func searchTerms(terms []string) []string {
+	var normalized []string
+	for _, term := range terms {
+		runes := []rune(term)
+		for _, span := range wordSpans(runes) {
+			normalized = append(normalized, normalizeSearchText(string(runes[span[0]:span[1]])))
+		}
+	}
+	return normalized
+}
+
+// matchesFilters reports whether a message passes the operators of the query, for searching without Postgres
+func (q SearchQuery) matchesFilters(message StoredMessage) bool {
+	if q.FromID != 0 && message.SenderID != q.FromID {
+		return false
+	}
+	if q.FromUsername != "" && !strings.EqualFold(message.Username, q.FromUsername) {
+		return false
+	}
+	if !q.After.IsZero() && message.SentDate.Before(q.After) {
+		return false
+	}
+	if !q.Before.IsZero() && !message.SentDate.Before(q.Before) {
+		return false
+	}
+	if q.Unmatched && len(message.Matches) > 0 {
+		return false
+	}
+	for _, matched := range q.Matched {
+		found := false
+		for _, match := range message.Matches {
+			if strings.EqualFold(match.Rule, matched) || strings.EqualFold(match.Pattern, matched) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, has := range q.Has {
+		if has == "link" && !linkRegexp.MatchString(message.Text) {
+			return false
+		}
+		if has != "link" && message.ContentType != has {
+			return false
+		}
+	}
+	return true
+}
+
+// matchMessage checks a single message against the query, as a search of the stored messages would.
+// It is how new messages are checked against subscriptions.
+func (q SearchQuery) matchMessage(message StoredMessage) (SearchResult, bool) {
+	if !q.matchesFilters(message) {
+		return SearchResult{}, false
+	}
+	rank, headline, found := 0.0, message.Text, true
+	if q.HasText() {
+		var phrases [][]string
+		for _, phrase := range q.Phrases {
+			phrases = append(phrases, searchTerms([]string{phrase}))
+		}
+		rank, headline, found = matchText(message.Text, searchTerms(q.Terms), phrases)
+	}
+	return SearchResult{StoredMessage: message, Rank: rank, Headline: headline}, found
+}
+
+// matchText reports whether every normalized term starts a word of the text and every phrase appears in it,
+// with a rank growing with the share of matched words and the text with those words between highlightStart and highlightStop
+func matchText(text string, terms []string, phrases [][]string) (rank float64, headline string, found bool) {
+	runes := []rune(text)
+	spans := wordSpans(runes)
+	words := make([]string, len(spans))
+	for i, span := range spans {
+		words[i] = normalizeSearchText(string(runes[span[0]:span[1]]))
+	}
+
+	matched := make([]bool, len(spans))
+	hits := 0
+	for _, term := range terms {
+		found := false
+		for i, word := range words {
+			if strings.HasPrefix(word, term) {
+				found = true
+				if !matched[i] {
+					matched[i] = true
+					hits++
+				}
+			}
+		}
+		if !found {
+			return 0, "", false
+		}
+	}
+	for _, phrase := range phrases {
+		found := false
+		for i := 0; len(phrase) > 0 && i+len(phrase) <= len(words); i++ {
+			if equalStrings(words[i:i+len(phrase)], phrase) {
+				found = true
+				for j := i; j < i+len(phrase); j++ {
+					if !matched[j] {
+						matched[j] = true
+						hits++
+					}
+				}
+			}
+		}
+		if !found {
+			return 0, "", false
+		}
+	}
+
+	var marked strings.Builder
+	last := 0
+	for i, span := range spans {
+		if !matched[i] {
+			continue
+		}
+		marked.WriteString(string(runes[last:span[0]]))
+		marked.WriteString(highlightStart + string(runes[span[0]:span[1]]) + highlightStop)
+		last = span[1]
+	}
+	marked.WriteString(string(runes[last:]))
+	// A text without words is only found by a query without words, it ranks last
+	if len(spans) == 0 {
+		return 0, marked.String(), true
+	}
+	return float64(hits) / float64(len(spans)), marked.String(), true
+}
+
+// equalStrings reports whether two lists hold the same strings in the same order
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,178 @@
+package structs
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// htmlTag matches the tags of the HTML sent to Telegram, which do not count toward the length of a message
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// formatSearchResult renders a search result in HTML: who sent it and when, a link to it and its text
+// with the matched words in bold, cut to at most maxText characters. Everything users wrote is escaped.
+func formatSearchResult(result SearchResult, language string, now time.Time, maxText int) string {
+	return searchResultHeader(result, language, now) + "\n" + highlightHTML(truncateText(result.Headline, maxText))
+}
+
+// appendSearchResults renders search results after the HTML text of a message. Their texts share what is left
+// of maxMessageLength once the lines above them are written, Telegram refuses longer messages.
+func appendSearchResults(text string, results []SearchResult, language string, now time.Time) string {
+	headers := make([]string, len(results))
+	used := renderedLength(text)
+	for i, result := range results {
+		headers[i] = searchResultHeader(result, language, now)
+		used += renderedLength(headers[i]) + len("\n\n\n")
+	}
+	for i, result := range results {
+		budget := (maxMessageLength - used) / (len(results) - i)
+		if budget < 1 {
+			budget = 1
+		}
+		body := highlightHTML(truncateText(result.Headline, budget))
+		used += renderedLength(body)
+		text += headers[i] + "\n" + body + "\n\n"
+	}
+	return text
+}
+
+// searchResultHeader renders the line above the text of a search result: who sent it and when, and a link to it
+func searchResultHeader(result SearchResult, language string, now time.Time) string {
+	line := senderLink(result.StoredMessage) + " · " + html.EscapeString(relativeDate(result.SentDate, now, language))
+	if link := messageLink(result.StoredMessage); link != "" {
+		line += fmt.Sprintf(` · <a href="%s">%s</a>`, link, openLabel(language))
+	}
+	return line
+}
+
+// renderedLength returns the length in characters of HTML once Telegram parsed it, the length its limits apply to
+func renderedLength(htmlText string) int {
+	return utf8.RuneCountInString(html.UnescapeString(htmlTag.ReplaceAllString(htmlText, "")))
+}
+
+// senderLink returns the name of the sender of a message linked to their profile
+func senderLink(message StoredMessage) string {
+	link := fmt.Sprintf(`<a href="tg://user?id=%d">%s</a>`, message.SenderID, html.EscapeString(senderName(message)))
+	if message.SenderName != "" && message.Username != "" {
+		link += " (@" + html.EscapeString(message.Username) + ")"
+	}
+	return link
+}
+
+// senderName returns the name of the sender of a message, their username or their ID if it is unknown
+func senderName(message StoredMessage) string {
+	if message.SenderName != "" {
+		return message.SenderName
+	}
+	if message.Username != "" {
+		return "@" + message.Username
+	}
+	return fmt.Sprintf("User %d", message.SenderID)
+}
+
+// messageLink returns a link opening a message in its supergroup, or "" if it cannot be linked to.
+// Links of the form t.me/c/<chat>/<message> work for members of private and public supergroups alike.
+func messageLink(message StoredMessage) string {
+	if message.ChatType != ChatSupergroup || message.MessageID == 0 {
+		return ""
+	}
+	chat := strconv.FormatInt(message.ChatID, 10)
+	if !strings.HasPrefix(chat, "-100") {
+		return ""
+	}
+	return fmt.Sprintf("https://t.me/c/%s/%d", strings.TrimPrefix(chat, "-100"), message.MessageID)
+}
+
+// openLabel is the text of the link to a message
+func openLabel(language string) string {
+	if language == "fa" {
+		return "مشاهده"
+	}
+	return "open"
+}
+
+// highlightHTML escapes a headline and turns its highlighted words bold.
+// Markers cut by truncation are balanced, so the HTML is always valid.
+func highlightHTML(headline string) string {
+	var out strings.Builder
+	open := false
+	for _, part := range strings.SplitAfter(headline, highlightStop) {
+		text, marked, hasStart := strings.Cut(part, highlightStart)
+		text = strings.TrimSuffix(text, highlightStop)
+		marked = strings.TrimSuffix(marked, highlightStop)
+
+		out.WriteString(html.EscapeString(text))
+		if hasStart {
+			out.WriteString("<b>" + html.EscapeString(marked))
+			open = true
+		}
+		if open && strings.HasSuffix(part, highlightStop) {
+			out.WriteString("</b>")
+			open = false
+		}
+	}
+	if open {
+		out.WriteString("</b>")
+	}
+	return out.String()
+}
+
+// relativeDate describes when something happened relative to now, in the chat language
+func relativeDate(date time.Time, now time.Time, language string) string {
+	elapsed := now.Sub(date)
+	fa := language == "fa"
+
+	var text string
+	switch {
+	case elapsed < time.Minute:
+		if fa {
+			return "همین الان"
+		}
+		return "just now"
+	case elapsed < time.Hour:
+		text = countLabel(int(elapsed/time.Minute), "minute", "دقیقه", fa)
+	case elapsed < 24*time.Hour:
+		text = countLabel(int(elapsed/time.Hour), "hour", "ساعت", fa)
+	case elapsed < 48*time.Hour:
+		if fa {
+			return "دیروز"
+		}
+		return "yesterday"
+	case elapsed < 7*24*time.Hour:
+		text = countLabel(int(elapsed/(24*time.Hour)), "day", "روز", fa)
+	default:
+		if fa {
+			return persianDigits(date.Format("2006/01/02"))
+		}
+		return date.Format("2 Jan 2006")
+	}
+	if fa {
+		return text + " پیش"
+	}
+	return text + " ago"
+}
+
+// countLabel writes a count with its unit, e.g. "1 minute", "5 minutes" or "۵ دقیقه"
+func countLabel(count int, unit string, persianUnit string, fa bool) string {
+	if fa {
+		return persianDigits(strconv.Itoa(count)) + " " + persianUnit
+	}
+	if count == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", count, unit)
+}
+
+// persianDigits writes the digits of a text with Persian digits
+func persianDigits(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return '۰' + (r - '0')
+		}
+		return r
+	}, text)
+}
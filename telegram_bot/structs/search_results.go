@@ -0,0 +1,234 @@
+package structs
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxMessageLength is the longest text Telegram accepts in a message
+const maxMessageLength = 4096
+
+// Values the results per page setting cycles through
+var pageSizeChoices = []int{5, 10, 20}
+
+// searchCache keeps the searches whose results are browsed with the Previous and Next buttons
+type searchCache struct {
+	mu       sync.Mutex
+	lastID   int64
+	searches map[int64]searchPages
+}
+
+// searchPages is a search whose results are browsed a page at a time
+type searchPages struct {
+	chatID   int64 // chat whose messages are searched
+	query    SearchQuery
+	pageSize int
+	total    int
+	cursors  []*SearchCursor // where each page seen so far starts, nil for the first one
+	page     int             // index of the page shown
+	expires  time.Time
+}
+
+// sendSearch sends the first page of the results of a search in chatID's messages to menuChatID
+func (b *TeleBot) sendSearch(menuChatID int64, chatID int64, query SearchQuery) {
+	pages := searchPages{
+		chatID:   chatID,
+		query:    query,
+		pageSize: b.ChatSettings(chatID).PageSize,
+		cursors:  []*SearchCursor{nil},
+		expires:  time.Now().Add(menuButtonTTL),
+	}
+	if pages.pageSize < 1 {
+		pages.pageSize = pageSizeChoices[0]
+	}
+	total, err := b.DB.CountMessages([]int64{chatID}, query)
+	if err != nil {
+		log.Println("Error counting messages:", err)
+		b.API.Send(tgbotapi.NewMessage(menuChatID, "Could not search the messages. Please try again."))
+		return
+	}
+	pages.total = total
+
+	id := b.saveSearch(0, pages)
+	text, keyboard, err := b.searchPage(menuChatID, id)
+	if err != nil {
+		log.Println("Error executing query:", err)
+		b.API.Send(tgbotapi.NewMessage(menuChatID, "Could not search the messages. Please try again."))
+		return
+	}
+
+	msg := tgbotapi.NewMessage(menuChatID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.DisableWebPagePreview = true
+	if keyboard != nil {
+		msg.ReplyMarkup = *keyboard
+	}
+	if _, err := b.API.Send(msg); err != nil {
+		log.Println("Error sending message:", err)
+	}
+}
+
+// HandleSearchPageButton shows the previous or next page of results in place, args hold the search ID and the direction
+func (b *TeleBot) HandleSearchPageButton(update tgbotapi.Update, args []string) {
+	query := update.CallbackQuery
+
+	if len(args) != 2 {
+		return
+	}
+	id, err := strconv.ParseInt(args[0], 36, 64)
+	if err != nil {
+		return
+	}
+	pages, ok := b.loadSearch(id)
+	if !ok {
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "This search has expired. Please search again."))
+		return
+	}
+	if !b.HasRole(pages.chatID, query.From.ID, RoleModerator) {
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "Only moderators can search the messages."))
+		return
+	}
+
+	switch args[1] {
+	case "p":
+		if pages.page == 0 {
+			b.API.Request(tgbotapi.NewCallback(query.ID, ""))
+			return
+		}
+		pages.page--
+	case "n":
+		// The start of the next page is known once the current one was shown
+		if pages.page+1 >= len(pages.cursors) {
+			b.API.Request(tgbotapi.NewCallback(query.ID, ""))
+			return
+		}
+		pages.page++
+	default:
+		return
+	}
+	b.saveSearch(id, pages)
+
+	text, keyboard, err := b.searchPage(query.Message.Chat.ID, id)
+	if err != nil {
+		log.Println("Error executing query:", err)
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "Could not search the messages. Please try again."))
+		return
+	}
+	b.API.Request(tgbotapi.NewCallback(query.ID, ""))
+
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	edit.ParseMode = tgbotapi.ModeHTML
+	edit.DisableWebPagePreview = true
+	edit.ReplyMarkup = keyboard
+	if _, err := b.API.Request(edit); err != nil {
+		log.Println("Error editing message:", err)
+	}
+}
+
+// searchPage loads the page shown of a search and returns its text in HTML and navigation buttons
+func (b *TeleBot) searchPage(menuChatID int64, id int64) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	pages, ok := b.loadSearch(id)
+	if !ok {
+		return "This search has expired. Please search again.", nil, nil
+	}
+
+	// One result more than the page tells whether there is a next page
+	results, err := b.DB.SearchMessages([]int64{pages.chatID}, pages.query, pages.cursors[pages.page], pages.pageSize+1)
+	if err != nil {
+		return "", nil, err
+	}
+	hasNext := len(results) > pages.pageSize
+	if hasNext {
+		results = results[:pages.pageSize]
+		if len(pages.cursors) == pages.page+1 {
+			cursor := results[len(results)-1].Cursor()
+			pages.cursors = append(pages.cursors, &cursor)
+			b.saveSearch(id, pages)
+		}
+	}
+
+	if len(results) == 0 {
+		return "No messages found.", nil, nil
+	}
+
+	pageCount := (pages.total + pages.pageSize - 1) / pages.pageSize
+	if pageCount < pages.page+1 {
+		pageCount = pages.page + 1
+	}
+	text := fmt.Sprintf("<b>Found %s.</b> Page %d of %d\n\n", countLabel(pages.total, "message", "", false), pages.page+1, pageCount)
+	text = appendSearchResults(text, results, b.ChatSettings(pages.chatID).Language, time.Now())
+
+	var row []tgbotapi.InlineKeyboardButton
+	target := strconv.FormatInt(id, 36)
+	if pages.page > 0 {
+		button, err := b.callbackButton("« Previous", menuChatID, menuButtonTTL, CallbackSearchPage, target, "p")
+		if err != nil {
+			return "", nil, err
+		}
+		row = append(row, button)
+	}
+	if hasNext {
+		button, err := b.callbackButton("Next »", menuChatID, menuButtonTTL, CallbackSearchPage, target, "n")
+		if err != nil {
+			return "", nil, err
+		}
+		row = append(row, button)
+	}
+	if len(row) == 0 {
+		return text, nil, nil
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
+	return text, &keyboard, nil
+}
+
+// saveSearch stores a search under its ID, or under a new one if id is 0, and forgets the expired ones
+func (b *TeleBot) saveSearch(id int64, pages searchPages) int64 {
+	b.searches.mu.Lock()
+	defer b.searches.mu.Unlock()
+
+	if b.searches.searches == nil {
+		b.searches.searches = make(map[int64]searchPages)
+	}
+	now := time.Now()
+	for key, search := range b.searches.searches {
+		if now.After(search.expires) {
+			delete(b.searches.searches, key)
+		}
+	}
+
+	if id == 0 {
+		b.searches.lastID++
+		id = b.searches.lastID
+	}
+	b.searches.searches[id] = pages
+	return id
+}
+
+// loadSearch returns a search that has not expired
+func (b *TeleBot) loadSearch(id int64) (searchPages, bool) {
+	b.searches.mu.Lock()
+	defer b.searches.mu.Unlock()
+
+	pages, ok := b.searches.searches[id]
+	if !ok || time.Now().After(pages.expires) {
+		return searchPages{}, false
+	}
+	// The cursors are appended to, the copy must not share them
+	pages.cursors = append([]*SearchCursor(nil), pages.cursors...)
+	return pages, true
+}
+
+// truncateText shortens a text to at most max characters, ending it with … when it was cut
+func truncateText(text string, max int) string {
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:max-1]) + "…"
+}
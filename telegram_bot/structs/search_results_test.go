@@ -0,0 +1,71 @@
+package structs
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchPageFitsInAMessage(t *testing.T) {
+	bot, _ := newFakeBot(t)
+	sent := time.Now().Add(-time.Hour)
+	for i := 1; i <= 21; i++ {
+		bot.DB.StoreMessage(StoredMessage{
+			ChatID:     -1001234567890,
+			MessageID:  i,
+			SenderID:   7,
+			Username:   strings.Repeat("u", 32),
+			SenderName: strings.Repeat("Long & <named> ", 4),
+			ChatType:   ChatSupergroup,
+			Text:       "needle " + strings.Repeat("hay & stack ", 100),
+			SentDate:   sent,
+		})
+	}
+
+	query, err := ParseSearchQuery("needle", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := searchPages{chatID: -1001234567890, query: query, pageSize: 20, total: 21, cursors: []*SearchCursor{nil}, expires: time.Now().Add(time.Hour)}
+	id := bot.saveSearch(0, pages)
+
+	text, keyboard, err := bot.searchPage(1, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length := renderedLength(text); length > maxMessageLength {
+		t.Errorf("page is %d characters, more than %d", length, maxMessageLength)
+	}
+	if strings.Count(text, "needle") != 20 {
+		t.Errorf("page shows %d results, want 20", strings.Count(text, "needle"))
+	}
+	if keyboard == nil {
+		t.Error("no button to the next page")
+	}
+}
+
+func TestSearchPageSingleResult(t *testing.T) {
+	bot, _ := newFakeBot(t)
+	bot.DB.StoreMessage(StoredMessage{ChatID: -100123, MessageID: 1, SenderID: 7, Text: "needle", SentDate: time.Now()})
+
+	query, err := ParseSearchQuery("needle", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := bot.saveSearch(0, searchPages{chatID: -100123, query: query, pageSize: 5, total: 1, cursors: []*SearchCursor{nil}, expires: time.Now().Add(time.Hour)})
+
+	text, _, err := bot.searchPage(1, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(text, "<b>Found 1 message.</b> Page 1 of 1") {
+		t.Errorf("page starts with %q, want the singular", strings.SplitN(text, "\n", 2)[0])
+	}
+}
+
+func TestRenderedLength(t *testing.T) {
+	// Tags are dropped and entities count as the character they stand for: "A & B · x"
+	if n := renderedLength(`<a href="tg://user?id=1">A &amp; B</a> · <b>x</b>`); n != 9 {
+		t.Errorf("renderedLength = %d, want 9", n)
+	}
+}
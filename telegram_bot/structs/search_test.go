@@ -0,0 +1,63 @@
+package structs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	query, err := ParseSearchQuery(`from:@ali after:2026-01-01 before:2026-02-01 matched:spam has:Link "exact phrase" word https://example.com`, "fa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SearchQuery{
+		Terms:        []string{"word", "https://example.com"},
+		Phrases:      []string{"exact phrase"},
+		Language:     "fa",
+		FromUsername: "ali",
+		After:        time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+		Before:       time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+		Matched:      []string{"spam"},
+		Has:          []string{"link"},
+	}
+	if !reflect.DeepEqual(query, want) {
+		t.Errorf("ParseSearchQuery =\n%+v\nwant\n%+v", query, want)
+	}
+
+	query, err = ParseSearchQuery("from:42 “curly quotes”", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if query.FromID != 42 || !reflect.DeepEqual(query.Phrases, []string{"curly quotes"}) {
+		t.Errorf("ParseSearchQuery = %+v, want from 42 and one phrase", query)
+	}
+}
+
+func TestParseSearchQueryErrors(t *testing.T) {
+	for _, text := range []string{
+		"",
+		`"not closed`,
+		"from:",
+		"from:@a from:@b",
+		"after:yesterday",
+		"has:nothing",
+		"unknown:value",
+		"after:2026-02-01 before:2026-01-01",
+		"after:2026-01-01 before:2026-01-01",
+	} {
+		if query, err := ParseSearchQuery(text, ""); err == nil {
+			t.Errorf("ParseSearchQuery(%q) = %+v, want an error", text, query)
+		}
+	}
+}
+
+func TestMatchTextWithoutWords(t *testing.T) {
+	rank, headline, found := matchText("🙂 !!", nil, nil)
+	if !found || rank != 0 || headline != "🙂 !!" {
+		t.Errorf("matchText = %v, %q, %v, want 0, the text, true", rank, headline, found)
+	}
+	if _, _, found := matchText("🙂 !!", []string{"word"}, nil); found {
+		t.Error("word found in a text without words")
+	}
+}
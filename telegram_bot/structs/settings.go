@@ -0,0 +1,113 @@
+package structs
+
+import (
+	"log"
+	"sync"
+)
+
+// ChatSettings holds the per-chat configuration of the bot
+type ChatSettings struct {
+	FilterWord string `json:"filter_word"` // set with /filter
+
+	Documents DocumentRules   `json:"documents"`
+	Stickers  StickerRules    `json:"stickers"`
+	Captcha   CaptchaSettings `json:"captcha"`
+
+	JoinScreening JoinScreening  `json:"join_screening"`
+	Raid          RaidSettings   `json:"raid"`
+	Review        ReviewSettings `json:"review"`
+
+	MaxStrikes  int               `json:"max_strikes"`  // removed messages before a user is muted, 0 means never
+	RuleActions map[string]Action `json:"rule_actions"` // action taken on the sender when a rule removes their message
+	Exemptions  Exemptions        `json:"exemptions"`
+
+	Verbose       bool   `json:"verbose"`        // reply to every message and explain removals
+	Language      string `json:"language"`       // language of the stored messages, used for search
+	RetentionDays int    `json:"retention_days"` // stored messages are deleted after this many days, 0 means kept forever
+	PageSize      int    `json:"page_size"`      // search results shown at once by /show
+}
+
+// Languages the chat language can be set to
+var Languages = []string{"en", "fa"}
+
+// RuleAction returns the action taken on the sender when a rule removes their message
+func (s ChatSettings) RuleAction(rule string) Action {
+	if action, ok := s.RuleActions[rule]; ok {
+		return action
+	}
+	return ActionNone
+}
+
+// DefaultChatSettings returns the settings used for chats that never changed them
+func DefaultChatSettings() ChatSettings {
+	return ChatSettings{
+		MaxStrikes: 3,
+		Verbose:    true,
+		Language:   "en",
+		PageSize:   5,
+		Exemptions: Exemptions{
+			ExemptAdmins: true,
+		},
+		Documents: DocumentRules{
+			BlockedExtensions: []string{".apk", ".exe", ".scr"},
+			ScanHashes:        true,
+		},
+		Captcha: CaptchaSettings{
+			Kind:    CaptchaMath,
+			Timeout: 120,
+		},
+		JoinScreening: JoinScreening{
+			Action: ActionKick,
+		},
+		Raid: RaidSettings{
+			MaxJoins:   10,
+			MaxFlagged: 10,
+			Window:     60,
+			CoolDown:   600,
+		},
+	}
+}
+
+// settingsCache keeps the settings of the chats the bot has seen in memory
+type settingsCache struct {
+	mu    sync.Mutex
+	chats map[int64]ChatSettings
+}
+
+// ChatSettings returns the settings of a chat, loading them from the database on first use
+func (b *TeleBot) ChatSettings(chatID int64) ChatSettings {
+	b.settings.mu.Lock()
+	defer b.settings.mu.Unlock()
+
+	if settings, ok := b.settings.chats[chatID]; ok {
+		return settings
+	}
+
+	settings, err := b.DB.GetChatSettings(chatID)
+	if err != nil {
+		// Fall back to the defaults without caching them so the next message retries
+		log.Println("Error loading chat settings:", err)
+		return DefaultChatSettings()
+	}
+
+	if b.settings.chats == nil {
+		b.settings.chats = make(map[int64]ChatSettings)
+	}
+	b.settings.chats[chatID] = settings
+	return settings
+}
+
+// SaveChatSettings stores the settings of a chat
+func (b *TeleBot) SaveChatSettings(chatID int64, settings ChatSettings) error {
+	if err := b.DB.SaveChatSettings(chatID, settings); err != nil {
+		return err
+	}
+
+	b.settings.mu.Lock()
+	defer b.settings.mu.Unlock()
+	if b.settings.chats == nil {
+		b.settings.chats = make(map[int64]ChatSettings)
+	}
+	b.settings.chats[chatID] = settings
+	return nil
+}
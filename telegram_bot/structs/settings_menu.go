@@ -0,0 +1,251 @@
+package structs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// retentionCheckInterval is how often stored messages past their chat's retention are deleted
+const retentionCheckInterval = time.Hour
+
+// Values the settings menu cycles through
+var (
+	retentionChoices  = []int{0, 7, 30, 90, 365}
+	memberDaysChoices = []int{0, 7, 30, 90}
+	ruleActionChoices = []Action{ActionNone, ActionMute, ActionKick, ActionBan}
+	settingsMenuPages = []string{"main", "rules", "exempt"}
+)
+
+// Settings opens the settings menu of a chat, or of the connected group in a private chat
+func (b *TeleBot) Settings(update tgbotapi.Update) {
+	message := update.Message
+
+	text, keyboard, err := b.settingsPage(message.Chat.ID, b.TargetChat(message), "main")
+	if err != nil {
+		log.Println("Error encoding callback data:", err)
+		b.reply(message, "Could not open the settings. Please try again.")
+		return
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyMarkup = keyboard
+	b.API.Send(msg)
+}
+
+// HandleSettingsButton applies a button press of the settings menu and redraws the menu in place,
+// args are the chat the settings belong to and the operation
+func (b *TeleBot) HandleSettingsButton(update tgbotapi.Update, args []string) {
+	query := update.CallbackQuery
+
+	if len(args) != 2 {
+		return
+	}
+	chatID, err := strconv.ParseInt(args[0], 36, 64)
+	if err != nil {
+		return
+	}
+	op := args[1]
+
+	if !b.HasRole(chatID, query.From.ID, RoleAdmin) {
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "Only admins can change the settings."))
+		return
+	}
+
+	if op == "close" {
+		b.API.Request(tgbotapi.NewCallback(query.ID, ""))
+		b.API.Request(tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, "Settings closed."))
+		return
+	}
+
+	page, changed := b.applySettingsOp(chatID, op)
+	if changed {
+		b.API.Request(tgbotapi.NewCallback(query.ID, "Saved."))
+	} else {
+		b.API.Request(tgbotapi.NewCallback(query.ID, ""))
+	}
+
+	text, keyboard, err := b.settingsPage(query.Message.Chat.ID, chatID, page)
+	if err != nil {
+		log.Println("Error encoding callback data:", err)
+		return
+	}
+	b.API.Request(tgbotapi.NewEditMessageTextAndMarkup(query.Message.Chat.ID, query.Message.MessageID, text, keyboard))
+}
+
+// applySettingsOp changes the setting behind a button and returns the page to show next
+// and whether the settings were saved
+func (b *TeleBot) applySettingsOp(chatID int64, op string) (string, bool) {
+	for _, page := range settingsMenuPages {
+		if op == page {
+			return page, false
+		}
+	}
+
+	settings := b.ChatSettings(chatID)
+	page := "main"
+
+	switch {
+	case op == "verbose":
+		settings.Verbose = !settings.Verbose
+	case op == "lang":
+		settings.Language = nextString(Languages, settings.Language)
+	case op == "retention":
+		settings.RetentionDays = nextInt(retentionChoices, settings.RetentionDays)
+	case op == "page":
+		settings.PageSize = nextInt(pageSizeChoices, settings.PageSize)
+	case op == "admins":
+		settings.Exemptions.ExemptAdmins = !settings.Exemptions.ExemptAdmins
+		page = "exempt"
+	case op == "days":
+		settings.Exemptions.MinMemberDays = nextInt(memberDaysChoices, settings.Exemptions.MinMemberDays)
+		page = "exempt"
+	case strings.HasPrefix(op, "r="):
+		rule := strings.TrimPrefix(op, "r=")
+		if !isRule(rule) {
+			return "rules", false
+		}
+		actions := make(map[string]Action)
+		for name, action := range settings.RuleActions {
+			actions[name] = action
+		}
+		actions[rule] = nextAction(settings.RuleAction(rule))
+		settings.RuleActions = actions
+		page = "rules"
+	default:
+		return page, false
+	}
+
+	if err := b.SaveChatSettings(chatID, settings); err != nil {
+		log.Println("Error saving chat settings:", err)
+		return page, false
+	}
+	return page, true
+}
+
+// settingsPage returns the text and buttons of a page of the settings menu of chatID, shown in menuChatID.
+// It fails if a button cannot be encoded, a menu missing some of its buttons would be misleading.
+func (b *TeleBot) settingsPage(menuChatID int64, chatID int64, page string) (string, tgbotapi.InlineKeyboardMarkup, error) {
+	settings := b.ChatSettings(chatID)
+	// The chat is written in base 36, like the chat of every callback, to stay within 64 bytes
+	target := strconv.FormatInt(chatID, 36)
+	var buttonErr error
+	button := func(label string, op string) tgbotapi.InlineKeyboardButton {
+		button, err := b.callbackButton(label, menuChatID, adminButtonTTL, CallbackSettings, target, op)
+		if err != nil && buttonErr == nil {
+			buttonErr = err
+		}
+		return button
+	}
+	back := tgbotapi.NewInlineKeyboardRow(button("« Back", "main"))
+
+	switch page {
+	case "rules":
+		var rows [][]tgbotapi.InlineKeyboardButton
+		for _, rule := range Rules {
+			label := fmt.Sprintf("%s: %s", rule, settings.RuleAction(rule))
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(button(label, "r="+rule)))
+		}
+		rows = append(rows, back)
+		return "Action taken on the sender when a rule removes their message. Press a rule to change it.",
+			tgbotapi.NewInlineKeyboardMarkup(rows...), buttonErr
+
+	case "exempt":
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(button("Admins exempt: "+onOff(settings.Exemptions.ExemptAdmins), "admins")),
+			tgbotapi.NewInlineKeyboardRow(button("Members exempt after: "+daysLabel(settings.Exemptions.MinMemberDays), "days")),
+			back,
+		)
+		return fmt.Sprintf("Exemptions\nTrusted users: %d (use /trust to change them)", len(settings.Exemptions.TrustedUsers)),
+			keyboard, buttonErr
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(button("Replies: "+onOff(settings.Verbose), "verbose")),
+		tgbotapi.NewInlineKeyboardRow(button("Rule actions", "rules")),
+		tgbotapi.NewInlineKeyboardRow(
+			button("Language: "+settings.Language, "lang"),
+			button("Keep messages: "+retentionLabel(settings.RetentionDays), "retention"),
+		),
+		tgbotapi.NewInlineKeyboardRow(button(fmt.Sprintf("Search results per page: %d", settings.PageSize), "page")),
+		tgbotapi.NewInlineKeyboardRow(button("Exemptions", "exempt")),
+		tgbotapi.NewInlineKeyboardRow(button("Close", "close")),
+	)
+	return "Settings of this chat. Press a button to change it.", keyboard, buttonErr
+}
+
+// ExpireMessages deletes the stored messages of chats that only keep them for a number of days
+func (b *TeleBot) ExpireMessages(ctx context.Context) {
+	for {
+		chats, err := b.DB.ChatsWithRetention()
+		if err != nil {
+			log.Println("Error loading message retention:", err)
+		}
+		for chatID, days := range chats {
+			if err := b.DB.PurgeMessages(chatID, time.Now().AddDate(0, 0, -days)); err != nil {
+				log.Println("Error deleting expired messages:", err)
+			}
+		}
+		if !sleepContext(ctx, retentionCheckInterval) {
+			return
+		}
+	}
+}
+
+// onOff returns a label for a boolean setting
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
+// daysLabel returns a label for a number of days where 0 means off
+func daysLabel(days int) string {
+	if days == 0 {
+		return "off"
+	}
+	return fmt.Sprintf("%d days", days)
+}
+
+// retentionLabel returns a label for the retention period, 0 means messages are kept forever
+func retentionLabel(days int) string {
+	if days == 0 {
+		return "forever"
+	}
+	return fmt.Sprintf("%d days", days)
+}
+
+// nextInt returns the choice after current, wrapping around, or the first one if current is not a choice
+func nextInt(choices []int, current int) int {
+	for i, choice := range choices {
+		if choice == current {
+			return choices[(i+1)%len(choices)]
+		}
+	}
+	return choices[0]
+}
+
+// nextString returns the choice after current, wrapping around, or the first one if current is not a choice
+func nextString(choices []string, current string) string {
+	for i, choice := range choices {
+		if choice == current {
+			return choices[(i+1)%len(choices)]
+		}
+	}
+	return choices[0]
+}
+
+// nextAction returns the rule action after current, wrapping around
+func nextAction(current Action) Action {
+	for i, choice := range ruleActionChoices {
+		if choice == current {
+			return ruleActionChoices[(i+1)%len(ruleActionChoices)]
+		}
+	}
+	return ruleActionChoices[0]
+}
@@ -0,0 +1,146 @@
+package structs
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// StickerRules decides which stickers, GIFs and custom emoji may be posted in a chat
+type StickerRules struct {
+	BlockedSets      []string `json:"blocked_sets"`       // sticker set names
+	BlockAnimated    bool     `json:"block_animated"`     // animated (.tgs) stickers
+	BlockVideo       bool     `json:"block_video"`        // video (.webm) stickers
+	BlockCustomEmoji bool     `json:"block_custom_emoji"` // messages containing custom emoji
+	MaxPerMinute     int      `json:"max_per_minute"`     // stickers and GIFs per user per minute, 0 means no limit
+}
+
+// IsSetBlocked reports whether a sticker set is on the chat's blocklist
+func (r StickerRules) IsSetBlocked(setName string) bool {
+	for _, blocked := range r.BlockedSets {
+		if strings.EqualFold(blocked, setName) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxStickerKinds is the number of stickers whose kind is remembered before the cache starts over
+const maxStickerKinds = 10000
+
+// stickerKinds remembers which stickers are video stickers, by file_unique_id.
+// The Bot API version in use does not send is_video, so the file has to be looked up.
+type stickerKinds struct {
+	mu    sync.Mutex
+	video map[string]bool
+}
+
+// isVideoSticker asks Telegram for the sticker file once, video stickers are stored as .webm
+func (b *TeleBot) isVideoSticker(sticker *tgbotapi.Sticker) bool {
+	b.stickers.mu.Lock()
+	video, ok := b.stickers.video[sticker.FileUniqueID]
+	b.stickers.mu.Unlock()
+	if ok {
+		return video
+	}
+
+	file, err := b.API.GetFile(tgbotapi.FileConfig{FileID: sticker.FileID})
+	if err != nil {
+		log.Println("Error fetching sticker file:", err)
+		return false
+	}
+	video = strings.HasSuffix(file.FilePath, ".webm")
+
+	b.stickers.mu.Lock()
+	defer b.stickers.mu.Unlock()
+	if b.stickers.video == nil || len(b.stickers.video) >= maxStickerKinds {
+		b.stickers.video = make(map[string]bool)
+	}
+	b.stickers.video[sticker.FileUniqueID] = video
+	return video
+}
+
+// hasCustomEmoji reports whether the text or caption of a message uses custom emoji
+func hasCustomEmoji(message *tgbotapi.Message) bool {
+	for _, entity := range append(message.Entities, message.CaptionEntities...) {
+		if entity.Type == "custom_emoji" {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckSticker removes stickers, GIFs and custom emoji that break the chat's sticker rules.
+// It returns true when the message was removed.
+func (b *TeleBot) CheckSticker(update tgbotapi.Update) bool {
+	message := update.Message
+	rules := b.ChatSettings(message.Chat.ID).Stickers
+
+	if rules.BlockCustomEmoji && hasCustomEmoji(message) {
+		b.Moderate(message, RuleSticker, "Custom emoji are not allowed in this chat.")
+		return true
+	}
+
+	if message.Sticker == nil && message.Animation == nil {
+		return false
+	}
+
+	if sticker := message.Sticker; sticker != nil {
+		if sticker.SetName != "" && rules.IsSetBlocked(sticker.SetName) {
+			b.Moderate(message, RuleSticker, "Stickers from this set are not allowed in this chat.")
+			return true
+		}
+		if rules.BlockAnimated && sticker.IsAnimated {
+			b.Moderate(message, RuleSticker, "Animated stickers are not allowed in this chat.")
+			return true
+		}
+		if rules.BlockVideo && b.isVideoSticker(sticker) {
+			b.Moderate(message, RuleSticker, "Video stickers are not allowed in this chat.")
+			return true
+		}
+	}
+
+	if rules.MaxPerMinute > 0 && message.From != nil {
+		key := fmt.Sprintf("stickers:%d:%d", message.Chat.ID, message.From.ID)
+		if !b.limiter.Allow(key, rules.MaxPerMinute, time.Minute) {
+			b.Moderate(message, RuleSticker, fmt.Sprintf("Only %d stickers or GIFs per minute are allowed in this chat.", rules.MaxPerMinute))
+			return true
+		}
+	}
+	return false
+}
+
+// BanStickerSet adds the set of the replied sticker to the chat's sticker set blocklist
+func (b *TeleBot) BanStickerSet(update tgbotapi.Update) {
+	message := update.Message
+
+	target := message.ReplyToMessage
+	if target == nil || target.Sticker == nil || target.Sticker.SetName == "" {
+		b.reply(message, "Reply to a sticker from a sticker set with /banstickerset to ban the whole set.")
+		return
+	}
+
+	// Look the set up so its canonical name and title are used
+	set, err := b.API.GetStickerSet(tgbotapi.GetStickerSetConfig{Name: target.Sticker.SetName})
+	if err != nil {
+		log.Println("Error fetching sticker set:", err)
+		b.reply(message, "Could not find this sticker set. Please try again.")
+		return
+	}
+
+	settings := b.ChatSettings(message.Chat.ID)
+	if !settings.Stickers.IsSetBlocked(set.Name) {
+		settings.Stickers.BlockedSets = append(append([]string(nil), settings.Stickers.BlockedSets...), set.Name)
+		if err := b.SaveChatSettings(message.Chat.ID, settings); err != nil {
+			b.reply(message, "Could not save the banned sticker set. Please try again.")
+			return
+		}
+	}
+
+	b.RemoveMessage(target, "")
+	b.reply(message, fmt.Sprintf("Sticker set \"%s\" banned.", set.Title))
+}
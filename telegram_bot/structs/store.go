@@ -0,0 +1,114 @@
+package structs
+
+import "time"
+
+// Store is everything the bot keeps between updates.
+// It is implemented by DB (Postgres), by FileStore (a single file) and by MemoryStore, which keeps nothing.
+type Store interface {
+	MessageStore
+	RuleStore
+	ModerationStore
+	SessionStore
+	UserStore
+	ChatStore
+	SubscriptionStore
+	Close() error
+}
+
+// MessageStore keeps the messages checked against the filter word
+type MessageStore interface {
+	StoreMessage(message StoredMessage) error
+	SearchMessages(chatIDs []int64, query SearchQuery, after *SearchCursor, limit int) ([]SearchResult, error)
+	CountMessages(chatIDs []int64, query SearchQuery) (int, error)
+	PurgeMessages(chatID int64, before time.Time) error
+}
+
+// RuleStore keeps the banned images and files the rules check against
+type RuleStore interface {
+	AddBannedImage(chatID int64, hash uint64, addedBy int64) error
+	BannedImages(chatID int64) ([]uint64, error)
+	DocumentHash(fileUniqueID string) (string, error)
+	StoreDocumentHash(fileUniqueID string, hash string) error
+	AddBadFile(chatID int64, hash string, addedBy int64) error
+	HasBadFiles(chatID int64) (bool, error)
+	IsBadFile(chatID int64, hash string) (bool, error)
+}
+
+// ModerationStore keeps the review queue, strikes, moderation history and appeals
+type ModerationStore interface {
+	CreateReviewItem(item ReviewItem) (int64, error)
+	GetReviewItem(id int64) (*ReviewItem, error)
+	DecideReviewItem(id int64, status string, decidedBy int64) error
+	ReviewStats(chatID int64) ([]ReviewStat, error)
+
+	AddStrike(chatID int64, userID int64) (int, error)
+	ResetStrikes(chatID int64, userID int64) error
+	RecordModeration(event ModerationEvent) (int64, error)
+	UserModerations(userID int64, limit int) ([]ModerationEvent, error)
+	GetModeration(id int64) (*ModerationEvent, error)
+
+	CreateAppeal(appeal Appeal) (int64, error)
+	GetAppeal(id int64) (*Appeal, error)
+	PendingAppeal(eventID int64) (*Appeal, error)
+	DecideAppeal(id int64, status string, decidedBy int64) error
+	AddAppealMessage(appealID int64, senderID int64, text string, sentDate time.Time) error
+}
+
+// SessionStore keeps the state that expires on its own: captcha challenges and lockdowns
+type SessionStore interface {
+	SaveCaptchaChallenge(challenge CaptchaChallenge) error
+	GetCaptchaChallenge(chatID int64, userID int64) (*CaptchaChallenge, error)
+	DeleteCaptchaChallenge(chatID int64, userID int64) error
+	ExpiredCaptchaChallenges(now time.Time) ([]CaptchaChallenge, error)
+
+	SaveLockdown(lockdown Lockdown) error
+	GetLockdown(chatID int64) (*Lockdown, error)
+	DeleteLockdown(chatID int64) error
+	ExpiredLockdowns(now time.Time) ([]Lockdown, error)
+}
+
+// UserStore keeps what is known about users: when they joined, their roles and their connected groups
+type UserStore interface {
+	TouchMember(chatID int64, userID int64, seen time.Time) (time.Time, error)
+	MemberChats(userID int64) ([]int64, error)
+	ForgetMember(chatID int64, userID int64) error
+
+	GetRole(chatID int64, userID int64) (Role, error)
+	SetRole(chatID int64, userID int64, role Role) error
+	DeleteRole(chatID int64, userID int64) error
+
+	SaveConnection(connection Connection) error
+	SetActiveConnection(userID int64, chatID int64) error
+	Connections(userID int64) ([]Connection, error)
+	ActiveConnection(userID int64) (*Connection, error)
+	DeleteConnection(userID int64, chatID int64) error
+}
+
+// ChatStore keeps the per-chat settings
+type ChatStore interface {
+	GetChatSettings(chatID int64) (ChatSettings, error)
+	SaveChatSettings(chatID int64, settings ChatSettings) error
+	ChatsWithRetention() (map[int64]int, error)
+}
+
+// SubscriptionStore keeps the searches users subscribed to and the matches waiting for their digest
+type SubscriptionStore interface {
+	CreateSubscription(subscription Subscription) (int64, error)
+	GetSubscription(id int64) (*Subscription, error)
+	UserSubscriptions(userID int64) ([]Subscription, error)
+	ChatSubscriptions(chatID int64) ([]Subscription, error)
+	SetSubscriptionDigest(id int64, digest bool) error
+	DeleteSubscription(id int64) error
+
+	AddSubscriptionMatch(match SubscriptionMatch) error
+	SubscriptionMatches(subscriptionID int64) ([]SubscriptionMatch, error)
+	ClearSubscriptionMatches(subscriptionID int64, throughID int64, sent time.Time) error
+	DueDigests(before time.Time) ([]Subscription, error)
+}
+
+// Every backend must stay complete
+var (
+	_ Store = (*DB)(nil)
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*FileStore)(nil)
+)
@@ -0,0 +1,542 @@
+package structs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Limits of subscriptions, so members cannot turn the bot into a firehose
+const (
+	maxSubscriptions      = 10  // per user
+	maxSubscriptionLength = 200 // characters of a subscribed query
+	maxAlertsPerHour      = 10  // per user, the matches over it wait for the next digest
+	maxDigestMatches      = 20  // shown in a digest, the older ones are only counted
+	maxAlertText          = 1000
+	digestInterval        = 24 * time.Hour
+	digestCheckInterval   = 10 * time.Minute
+	notificationQueueSize = 1000 // stored messages waiting to be checked against the subscriptions
+)
+
+// Subscription is a search a user wants to hear about: every new message of the chat it finds
+// is sent to them right away, or in a daily digest
+type Subscription struct {
+	ID          int64
+	UserID      int64
+	ChatID      int64
+	ChatTitle   string
+	Query       string // as typed after /subscribe
+	Digest      bool   // false for immediate alerts
+	CreatedDate time.Time
+	LastDigest  time.Time
+}
+
+// SubscriptionMatch is a message found by a subscription, waiting for its digest.
+// Only the sender, date, link and headline of the message are kept.
+type SubscriptionMatch struct {
+	ID             int64
+	SubscriptionID int64
+	Result         SearchResult
+}
+
+// subscriptionCache keeps the subscriptions of each chat, every stored message is checked against them
+type subscriptionCache struct {
+	mu    sync.Mutex
+	chats map[int64][]Subscription
+}
+
+// subscriptionDrafts keeps the /subscribe queries waiting for the user to pick a group
+type subscriptionDrafts struct {
+	mu     sync.Mutex
+	lastID int64
+	drafts map[int64]subscriptionDraft
+}
+
+// subscriptionDraft is a /subscribe query waiting for the user to pick a group
+type subscriptionDraft struct {
+	userID  int64
+	query   string
+	expires time.Time
+}
+
+// parseSubscription checks a query typed after /subscribe
+func parseSubscription(text string) error {
+	if text == "" {
+		return errors.New("Usage: /subscribe <query>, e.g. /subscribe \"team meeting\" or /subscribe from:@ali has:link")
+	}
+	if utf8.RuneCountInString(text) > maxSubscriptionLength {
+		return fmt.Errorf("Please keep the query under %d characters.", maxSubscriptionLength)
+	}
+	query, err := ParseSearchQuery(text, "")
+	if err != nil {
+		return err
+	}
+	if len(query.Matched) > 0 {
+		return errors.New("Only moderators can search with matched:, use /show in the group.")
+	}
+	return nil
+}
+
+// Subscribe stores a query and tells the sender about the new messages of one of their groups it finds.
+// When the sender is in several groups, they pick one with the buttons.
+func (b *TeleBot) Subscribe(update tgbotapi.Update) {
+	message := update.Message
+	text := strings.TrimSpace(message.CommandArguments())
+
+	if err := parseSubscription(text); err != nil {
+		b.reply(message, err.Error())
+		return
+	}
+	if err := b.checkSubscriptionCount(message.From.ID); err != nil {
+		b.reply(message, err.Error())
+		return
+	}
+
+	chats := b.memberChats(message.From.ID)
+	if len(chats) == 0 {
+		b.reply(message, "I have not seen you in any of my groups yet. Write a message in the group first, then try again.")
+		return
+	}
+	if len(chats) == 1 {
+		reply, err := b.subscribe(message.From.ID, chats[0], text)
+		if err != nil {
+			reply = err.Error()
+		}
+		b.reply(message, reply)
+		return
+	}
+
+	id := b.saveDraft(subscriptionDraft{userID: message.From.ID, query: text, expires: time.Now().Add(menuButtonTTL)})
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, chatID := range chats {
+		button, err := b.callbackButton(b.chatTitle(chatID), message.Chat.ID, menuButtonTTL, CallbackSubscribe,
+			strconv.FormatInt(id, 36), strconv.FormatInt(chatID, 36))
+		if err != nil {
+			log.Println("Error encoding callback data:", err)
+			continue
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(button))
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, "Which group should I watch for this query?")
+	msg.ReplyToMessageID = message.MessageID
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	b.API.Send(msg)
+}
+
+// HandleSubscribeButton subscribes to the messages of the group picked for a /subscribe query,
+// args hold the draft ID and the chat ID
+func (b *TeleBot) HandleSubscribeButton(update tgbotapi.Update, args []string) {
+	query := update.CallbackQuery
+
+	if len(args) != 2 {
+		return
+	}
+	id, err := strconv.ParseInt(args[0], 36, 64)
+	if err != nil {
+		return
+	}
+	chatID, err := strconv.ParseInt(args[1], 36, 64)
+	if err != nil {
+		return
+	}
+	draft, ok := b.loadDraft(id)
+	if !ok || draft.userID != query.From.ID {
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "This button has expired. Please use /subscribe again."))
+		return
+	}
+	if !containsChat(b.memberChats(query.From.ID), chatID) {
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "You are no longer a member of that group."))
+		return
+	}
+
+	text, err := b.subscribe(query.From.ID, chatID, draft.query)
+	if err != nil {
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, err.Error()))
+		return
+	}
+	// A second press must not subscribe twice
+	b.deleteDraft(id)
+	b.API.Request(tgbotapi.NewCallback(query.ID, "Subscribed."))
+	b.API.Request(tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text))
+}
+
+// subscribe stores a subscription of a user to a chat and returns the confirmation
+func (b *TeleBot) subscribe(userID int64, chatID int64, text string) (string, error) {
+	// The limit is checked again, other subscriptions may have been added while the group was picked
+	if err := b.checkSubscriptionCount(userID); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	subscription := Subscription{
+		UserID:      userID,
+		ChatID:      chatID,
+		ChatTitle:   b.chatTitle(chatID),
+		Query:       text,
+		CreatedDate: now,
+		LastDigest:  now,
+	}
+	if _, err := b.DB.CreateSubscription(subscription); err != nil {
+		log.Println("Error storing subscription:", err)
+		return "", errors.New("Could not save the subscription. Please try again.")
+	}
+	b.forgetSubscriptions(chatID)
+	return fmt.Sprintf("Subscribed to %s in %s. I will message you when a new message matches.\n"+
+		"Use /subscriptions to get a daily digest instead, or to unsubscribe.", text, subscription.ChatTitle), nil
+}
+
+// checkSubscriptionCount refuses a new subscription to users who have too many already
+func (b *TeleBot) checkSubscriptionCount(userID int64) error {
+	subscriptions, err := b.DB.UserSubscriptions(userID)
+	if err != nil {
+		log.Println("Error loading subscriptions:", err)
+		return errors.New("Could not load your subscriptions. Please try again.")
+	}
+	if len(subscriptions) >= maxSubscriptions {
+		return fmt.Errorf("You already have %d subscriptions. Remove one with /subscriptions first.", len(subscriptions))
+	}
+	return nil
+}
+
+// Subscriptions lists the subscriptions of the sender with buttons to switch or remove each of them
+func (b *TeleBot) Subscriptions(update tgbotapi.Update) {
+	message := update.Message
+
+	text, keyboard := b.subscriptionsMenu(message.Chat.ID, message.From.ID)
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	if keyboard != nil {
+		msg.ReplyMarkup = *keyboard
+	}
+	b.API.Send(msg)
+}
+
+// subscriptionsMenu returns the subscriptions of a user with a row of buttons for each of them
+func (b *TeleBot) subscriptionsMenu(chatID int64, userID int64) (string, *tgbotapi.InlineKeyboardMarkup) {
+	subscriptions, err := b.DB.UserSubscriptions(userID)
+	if err != nil {
+		log.Println("Error loading subscriptions:", err)
+		return "Could not load your subscriptions. Please try again.", nil
+	}
+	if len(subscriptions) == 0 {
+		return "You have no subscriptions. Send /subscribe <query> to be told about the messages of your groups it finds.", nil
+	}
+
+	text := "Your subscriptions:\n"
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, subscription := range subscriptions {
+		mode, switchLabel, switchArg := "alerts", fmt.Sprintf("%d: Daily digest", i+1), "d"
+		if subscription.Digest {
+			mode, switchLabel, switchArg = "daily digest", fmt.Sprintf("%d: Alerts", i+1), "a"
+		}
+		text += fmt.Sprintf("%d. %s in %s (%s)\n", i+1, subscription.Query, subscription.ChatTitle, mode)
+
+		id := strconv.FormatInt(subscription.ID, 36)
+		switchButton, err := b.callbackButton(switchLabel, chatID, menuButtonTTL, CallbackSubscriptions, id, switchArg)
+		if err != nil {
+			log.Println("Error encoding callback data:", err)
+			continue
+		}
+		removeButton, err := b.callbackButton(fmt.Sprintf("%d: Unsubscribe", i+1), chatID, menuButtonTTL, CallbackSubscriptions, id, "r")
+		if err != nil {
+			log.Println("Error encoding callback data:", err)
+			continue
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(switchButton, removeButton))
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return text, &keyboard
+}
+
+// HandleSubscriptionButton switches a subscription between alerts and a digest or removes it,
+// args hold the subscription ID and "a", "d" or "r"
+func (b *TeleBot) HandleSubscriptionButton(update tgbotapi.Update, args []string) {
+	query := update.CallbackQuery
+
+	if len(args) != 2 {
+		return
+	}
+	id, err := strconv.ParseInt(args[0], 36, 64)
+	if err != nil {
+		return
+	}
+	subscription, err := b.DB.GetSubscription(id)
+	if err != nil {
+		log.Println("Error loading subscription:", err)
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "Could not load the subscription. Please try again."))
+		return
+	}
+	if subscription == nil || subscription.UserID != query.From.ID {
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "This subscription no longer exists."))
+		return
+	}
+
+	var answer string
+	switch args[1] {
+	case "a":
+		err = b.DB.SetSubscriptionDigest(id, false)
+		answer = "You will be alerted right away."
+	case "d":
+		err = b.DB.SetSubscriptionDigest(id, true)
+		answer = "You will get a daily digest."
+	case "r":
+		err = b.DB.DeleteSubscription(id)
+		answer = "Unsubscribed."
+	default:
+		return
+	}
+	b.forgetSubscriptions(subscription.ChatID)
+	if err != nil {
+		log.Println("Error updating subscription:", err)
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "Could not update the subscription. Please try again."))
+		return
+	}
+	b.API.Request(tgbotapi.NewCallback(query.ID, answer))
+
+	text, keyboard := b.subscriptionsMenu(query.Message.Chat.ID, query.From.ID)
+	if keyboard == nil {
+		b.API.Request(tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text))
+		return
+	}
+	b.API.Request(tgbotapi.NewEditMessageTextAndMarkup(query.Message.Chat.ID, query.Message.MessageID, text, *keyboard))
+}
+
+// chatSubscriptions returns the subscriptions to a chat, loading them once
+func (b *TeleBot) chatSubscriptions(chatID int64) ([]Subscription, error) {
+	b.subscribers.mu.Lock()
+	subscriptions, ok := b.subscribers.chats[chatID]
+	b.subscribers.mu.Unlock()
+	if ok {
+		return subscriptions, nil
+	}
+
+	subscriptions, err := b.DB.ChatSubscriptions(chatID)
+	if err != nil {
+		return nil, err
+	}
+	b.subscribers.mu.Lock()
+	defer b.subscribers.mu.Unlock()
+	if b.subscribers.chats == nil {
+		b.subscribers.chats = make(map[int64][]Subscription)
+	}
+	b.subscribers.chats[chatID] = subscriptions
+	return subscriptions, nil
+}
+
+// forgetSubscriptions drops the cached subscriptions of a chat after one of them changed
+func (b *TeleBot) forgetSubscriptions(chatID int64) {
+	b.subscribers.mu.Lock()
+	defer b.subscribers.mu.Unlock()
+	delete(b.subscribers.chats, chatID)
+}
+
+// QueueNotification hands a stored message to DeliverNotifications if anyone is subscribed to its chat.
+// The queue is bounded: during a flood the messages that do not fit are not checked.
+func (b *TeleBot) QueueNotification(message StoredMessage) {
+	subscriptions, err := b.chatSubscriptions(message.ChatID)
+	if err != nil {
+		log.Println("Error loading subscriptions:", err)
+		return
+	}
+	if len(subscriptions) == 0 {
+		return
+	}
+	select {
+	case b.notifications <- message:
+	default:
+		log.Printf("Notification queue full, message %d of chat %d not checked", message.MessageID, message.ChatID)
+	}
+}
+
+// DeliverNotifications checks the queued messages against the subscriptions one at a time, until ctx is cancelled
+func (b *TeleBot) DeliverNotifications(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case message := <-b.notifications:
+			func() {
+				defer recoverUpdate("subscriber notification")
+				b.NotifySubscribers(message)
+			}()
+		}
+	}
+}
+
+// NotifySubscribers checks a new message against the subscriptions to its chat.
+// Subscribers in alert mode are told right away, within maxAlertsPerHour, the others at their next digest.
+func (b *TeleBot) NotifySubscribers(message StoredMessage) {
+	subscriptions, err := b.chatSubscriptions(message.ChatID)
+	if err != nil {
+		log.Println("Error loading subscriptions:", err)
+		return
+	}
+
+	for _, subscription := range subscriptions {
+		// Nobody needs to hear about their own messages
+		if subscription.UserID == message.SenderID {
+			continue
+		}
+		query, err := ParseSearchQuery(subscription.Query, message.Language)
+		if err != nil {
+			continue
+		}
+		result, found := query.matchMessage(message)
+		if !found {
+			continue
+		}
+		// Users who left the group are not told about it any more
+		if !b.stillMember(subscription.UserID, subscription.ChatID) {
+			continue
+		}
+
+		if !subscription.Digest && b.limiter.Allow(fmt.Sprintf("subscription-alerts:%d", subscription.UserID), maxAlertsPerHour, time.Hour) {
+			b.sendAlert(subscription, result)
+			continue
+		}
+		if err := b.DB.AddSubscriptionMatch(SubscriptionMatch{SubscriptionID: subscription.ID, Result: result}); err != nil {
+			log.Println("Error storing subscription match:", err)
+		}
+	}
+}
+
+// sendAlert tells a subscriber about a message their subscription found
+func (b *TeleBot) sendAlert(subscription Subscription, result SearchResult) {
+	text := fmt.Sprintf("🔔 <b>%s</b> in %s\n", html.EscapeString(subscription.Query), html.EscapeString(subscription.ChatTitle))
+	text += formatSearchResult(result, b.ChatSettings(subscription.ChatID).Language, time.Now(), maxAlertText)
+
+	msg := tgbotapi.NewMessage(subscription.UserID, text)
+	msg.ParseMode = tgbotapi.ModeHTML
+	msg.DisableWebPagePreview = true
+	if _, err := b.API.Send(msg); err != nil {
+		log.Println("Error sending subscription alert:", err)
+	}
+}
+
+// SendDigests sends the matches waiting for each subscription, at most once per digestInterval
+func (b *TeleBot) SendDigests(ctx context.Context) {
+	for {
+		subscriptions, err := b.DB.DueDigests(time.Now().Add(-digestInterval))
+		if err != nil {
+			log.Println("Error loading due digests:", err)
+		}
+		for _, subscription := range subscriptions {
+			b.sendDigest(subscription)
+		}
+		if !sleepContext(ctx, digestCheckInterval) {
+			return
+		}
+	}
+}
+
+// sendDigest sends the matches waiting for a subscription in one message and forgets them once it was sent.
+// Matches of users who left the group or blocked the bot are forgotten too, so they do not pile up.
+func (b *TeleBot) sendDigest(subscription Subscription) {
+	matches, err := b.DB.SubscriptionMatches(subscription.ID)
+	if err != nil {
+		log.Println("Error loading subscription matches:", err)
+		return
+	}
+	if len(matches) == 0 {
+		return
+	}
+	last := matches[len(matches)-1].ID
+
+	if b.stillMember(subscription.UserID, subscription.ChatID) {
+		text := fmt.Sprintf("📰 <b>%d new messages for %s in %s</b>\n\n",
+			len(matches), html.EscapeString(subscription.Query), html.EscapeString(subscription.ChatTitle))
+		shown := matches
+		if len(shown) > maxDigestMatches {
+			shown = shown[len(shown)-maxDigestMatches:]
+			text += fmt.Sprintf("The last %d of them:\n\n", maxDigestMatches)
+		}
+		results := make([]SearchResult, len(shown))
+		for i, match := range shown {
+			results[i] = match.Result
+		}
+		text = appendSearchResults(text, results, b.ChatSettings(subscription.ChatID).Language, time.Now())
+
+		msg := tgbotapi.NewMessage(subscription.UserID, text)
+		msg.ParseMode = tgbotapi.ModeHTML
+		msg.DisableWebPagePreview = true
+		if _, err := b.API.Send(msg); err != nil {
+			log.Println("Error sending subscription digest:", err)
+			// The matches are kept for the next digest, unless the user blocked the bot
+			var apiErr *tgbotapi.Error
+			if !errors.As(err, &apiErr) || apiErr.Code != http.StatusForbidden {
+				return
+			}
+		}
+	}
+
+	if err := b.DB.ClearSubscriptionMatches(subscription.ID, last, time.Now()); err != nil {
+		log.Println("Error clearing subscription matches:", err)
+	}
+}
+
+// chatTitle returns the title of a chat, or its ID if Telegram does not tell
+func (b *TeleBot) chatTitle(chatID int64) string {
+	chat, err := b.API.GetChat(tgbotapi.ChatInfoConfig{ChatConfig: tgbotapi.ChatConfig{ChatID: chatID}})
+	if err != nil || chat.Title == "" {
+		return strconv.FormatInt(chatID, 10)
+	}
+	return chat.Title
+}
+
+// containsChat reports whether a list of chats holds a chat
+func containsChat(chats []int64, chatID int64) bool {
+	for _, id := range chats {
+		if id == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// saveDraft keeps a /subscribe query under a new ID and forgets the expired ones
+func (b *TeleBot) saveDraft(draft subscriptionDraft) int64 {
+	b.drafts.mu.Lock()
+	defer b.drafts.mu.Unlock()
+
+	if b.drafts.drafts == nil {
+		b.drafts.drafts = make(map[int64]subscriptionDraft)
+	}
+	now := time.Now()
+	for key, existing := range b.drafts.drafts {
+		if now.After(existing.expires) {
+			delete(b.drafts.drafts, key)
+		}
+	}
+
+	b.drafts.lastID++
+	b.drafts.drafts[b.drafts.lastID] = draft
+	return b.drafts.lastID
+}
+
+// loadDraft returns a /subscribe query that has not expired
+func (b *TeleBot) loadDraft(id int64) (subscriptionDraft, bool) {
+	b.drafts.mu.Lock()
+	defer b.drafts.mu.Unlock()
+
+	draft, ok := b.drafts.drafts[id]
+	if !ok || time.Now().After(draft.expires) {
+		return subscriptionDraft{}, false
+	}
+	return draft, true
+}
+
+// deleteDraft forgets a /subscribe query once it was subscribed to
+func (b *TeleBot) deleteDraft(id int64) {
+	b.drafts.mu.Lock()
+	defer b.drafts.mu.Unlock()
+	delete(b.drafts.drafts, id)
+}
@@ -0,0 +1,106 @@
+package structs
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// digestFixture subscribes user 7 to a group they are a member of, with matches waiting for the digest
+func digestFixture(t *testing.T) (*TeleBot, *fakeAPI, Subscription) {
+	t.Helper()
+	bot, api := newFakeBot(t)
+	api.setResult("getChatMember", `{"user":{"id":7},"status":"member"}`, 0)
+	if _, err := bot.DB.TouchMember(-100123, 7, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	subscription := Subscription{UserID: 7, ChatID: -100123, ChatTitle: "Group", Query: "needle", Digest: true}
+	var err error
+	if subscription.ID, err = bot.DB.CreateSubscription(subscription); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= maxDigestMatches; i++ {
+		result := SearchResult{
+			StoredMessage: StoredMessage{ChatID: -100123, MessageID: i, SenderID: 8, SenderName: strings.Repeat("Long & <named> ", 4), ChatType: ChatSupergroup, SentDate: time.Now()},
+			Headline:      highlightStart + "needle" + highlightStop + strings.Repeat(" hay & stack", 100),
+		}
+		if err := bot.DB.AddSubscriptionMatch(SubscriptionMatch{SubscriptionID: subscription.ID, Result: result}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bot, api, subscription
+}
+
+func waitingMatches(t *testing.T, bot *TeleBot, subscription Subscription) int {
+	t.Helper()
+	matches, err := bot.DB.SubscriptionMatches(subscription.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(matches)
+}
+
+func TestSendDigest(t *testing.T) {
+	bot, api, subscription := digestFixture(t)
+	bot.sendDigest(subscription)
+
+	calls := api.called("sendMessage")
+	if len(calls) != 1 {
+		t.Fatalf("sendMessage calls = %d, want 1", len(calls))
+	}
+	if length := renderedLength(calls[0].Params["text"]); length > maxMessageLength {
+		t.Errorf("digest is %d characters, more than %d", length, maxMessageLength)
+	}
+	if n := waitingMatches(t, bot, subscription); n != 0 {
+		t.Errorf("matches waiting after the digest = %d, want 0", n)
+	}
+}
+
+func TestSendDigestFailed(t *testing.T) {
+	bot, api, subscription := digestFixture(t)
+
+	// Matches wait for the next digest when it could not be sent
+	api.setResult("sendMessage", "", http.StatusTooManyRequests)
+	bot.sendDigest(subscription)
+	if n := waitingMatches(t, bot, subscription); n != maxDigestMatches {
+		t.Errorf("matches waiting after a failed digest = %d, want %d", n, maxDigestMatches)
+	}
+
+	// Users who blocked the bot would never get them
+	api.setResult("sendMessage", "", http.StatusForbidden)
+	bot.sendDigest(subscription)
+	if n := waitingMatches(t, bot, subscription); n != 0 {
+		t.Errorf("matches waiting after the user blocked the bot = %d, want 0", n)
+	}
+}
+
+func TestNotifySubscribersThrottled(t *testing.T) {
+	bot, api := newFakeBot(t)
+	api.setResult("getChatMember", `{"user":{"id":8},"status":"member"}`, http.StatusTooManyRequests)
+	bot.DB.TouchMember(-100123, 8, time.Now())
+	if _, err := bot.DB.CreateSubscription(Subscription{UserID: 8, ChatID: -100123, ChatTitle: "Group", Query: "news"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Telegram not answering must not cost the subscriber the alert
+	bot.NotifySubscribers(StoredMessage{ChatID: -100123, MessageID: 1, SenderID: 7, ChatType: ChatSupergroup, Text: "big news today", SentDate: time.Now()})
+	if len(api.called("sendMessage")) != 1 {
+		t.Errorf("alerts sent = %d, want 1", len(api.called("sendMessage")))
+	}
+}
+
+func TestSubscriptionCacheForgotten(t *testing.T) {
+	bot, _ := newFakeBot(t)
+	if subscriptions, _ := bot.chatSubscriptions(-100123); len(subscriptions) != 0 {
+		t.Fatalf("subscriptions = %v, want none", subscriptions)
+	}
+
+	if _, err := bot.subscribe(8, -100123, "news"); err != nil {
+		t.Fatal(err)
+	}
+	if subscriptions, _ := bot.chatSubscriptions(-100123); len(subscriptions) != 1 {
+		t.Errorf("subscriptions after subscribing = %d, want 1", len(subscriptions))
+	}
+}
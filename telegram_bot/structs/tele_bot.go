@@ -1,30 +1,54 @@
 package structs
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 type TeleBot struct {
-	API            *tgbotapi.BotAPI
-	FilterWord     string
-	SearchWord     string
-	WaitingForWord bool // New state variable to track if bot is waiting for filter word
-	IsSearching    bool // New state variable to track if the bot is searching messages
-	DB             *DB  // Database connection
+	API          *tgbotapi.BotAPI
+	DB           Store  // Storage backend: Postgres, or memory in development
+	FileEndpoint string // URL format used to download files, see tgbotapi.FileEndpoint
+	OwnerID      int64  // Telegram user ID of the bot owner, who may use every command
+
+	router  *Router // Registered commands
+	stopped bool    // Set by /stop, the update loop then stops the background tasks and returns
+
+	settings settingsCache // Per-chat settings loaded from the database
+	limiter  rateLimiter   // Per-user rate limits
+	stickers stickerKinds  // Which stickers are video stickers
+
+	sessions      sessions           // Running conversations
+	admins        adminCache         // Administrators of each chat
+	callbacks     callbackSigner     // Signs the data of inline buttons
+	searches      searchCache        // Searches browsed page by page
+	members       memberCache        // Chats each user may search inline
+	subscribers   subscriptionCache  // Subscriptions of each chat
+	notifications chan StoredMessage // Stored messages waiting to be checked against the subscriptions
+	drafts        subscriptionDrafts // /subscribe queries waiting for a group to be picked
 }
 
 // Initialize the bot
-func NewBot(token string, db *DB) (*TeleBot, error) {
-	botAPI, err := tgbotapi.NewBotAPI(token)
+func NewBot(token string, db Store) (*TeleBot, error) {
+	return NewBotWithEndpoints(token, tgbotapi.APIEndpoint, tgbotapi.FileEndpoint, db)
+}
+
+// NewBotWithEndpoints initializes the bot against a custom Bot API server (e.g. a local or fake one)
+func NewBotWithEndpoints(token string, apiEndpoint string, fileEndpoint string, db Store) (*TeleBot, error) {
+	botAPI, err := tgbotapi.NewBotAPIWithAPIEndpoint(token, apiEndpoint)
 	if err != nil {
 		return nil, err
 	}
-	return &TeleBot{API: botAPI, DB: db}, nil
+	bot := &TeleBot{API: botAPI, DB: db, FileEndpoint: fileEndpoint, callbacks: newCallbackSigner(token), notifications: make(chan StoredMessage, notificationQueueSize)}
+	bot.registerCommands()
+	return bot, nil
 }
 
 // Bot runs and gets messages from the user
@@ -34,142 +58,187 @@ func (b *TeleBot) StartListening() {
 
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
+	// chat_member updates are only delivered when asked for explicitly
+	u.AllowedUpdates = []string{"message", "callback_query", "chat_member", "inline_query"}
 
 	updates := b.API.GetUpdatesChan(u)
 
+	// Publish the command menus generated from the registry
+	b.RegisterCommands()
+
+	// Kick new members who did not solve their captcha in time and end finished lockdowns.
+	// The tasks are stopped and waited for before returning, the caller closes the store after them.
+	ctx, cancel := context.WithCancel(context.Background())
+	var tasks sync.WaitGroup
+	for _, task := range []func(context.Context){b.ExpireCaptchas, b.ExpireLockdowns, b.ExpireMessages, b.SendDigests, b.ExpireConversations, b.DeliverNotifications} {
+		tasks.Add(1)
+		go func(task func(context.Context)) {
+			defer tasks.Done()
+			task(ctx)
+		}(task)
+	}
+	defer tasks.Wait()
+	defer cancel()
+
 	for update := range updates {
-		if update.Message != nil {
-			if update.Message.IsCommand() {
-				switch update.Message.Command() {
-				case "start":
-					b.Start(update)
-				case "filter":
-					b.WaitingForWord = true // Set WaitingForWord to true when /filter command received
-					b.Filter(update)
-				case "stop":
-					b.Stop(update)
-					return // Stop processing updates
-				case "help":
-					b.Help(update)
-				case "show":
-					b.Show(update)
-				default:
-					b.ProcessMessage(update)
-				}
-			} else {
-				if b.WaitingForWord {
-					b.WordReceiver(update)
-				} else if !b.WaitingForWord && !b.IsSearching {
-					b.ProcessMessage(update)
-				}
+		b.HandleUpdate(update)
+		// Nothing is processed after /stop
+		if b.stopped {
+			b.API.StopReceivingUpdates()
+			return
+		}
+	}
+}
+
+// sleepContext waits for d, it returns false if ctx is cancelled first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// HandleUpdate passes an update to its handler, a panic only loses that update
+func (b *TeleBot) HandleUpdate(update tgbotapi.Update) {
+	if update.Message != nil {
+		if update.Message.IsCommand() {
+			// Commands recover in their own middleware to answer the user
+			b.HandleCommand(update)
+		} else if len(update.Message.NewChatMembers) > 0 {
+			defer recoverUpdate("new members")
+			b.HandleNewMembers(update)
+		} else {
+			defer recoverUpdate("message")
+			// Messages breaking the chat rules are removed before anything else looks at them
+			if !b.IsExempt(update.Message) && b.CheckRules(update) {
+				return
+			}
+			// Answers to a running conversation are not filtered
+			if b.Converse(update) {
+				return
 			}
-		} else if update.CallbackQuery != nil {
-			// Handle callback query
-			b.HandleCallbackQuery(update)
+			b.ProcessMessage(update)
 		}
+	} else if update.CallbackQuery != nil {
+		defer recoverUpdate("callback query")
+		b.HandleCallbackQuery(update)
+	} else if update.ChatMember != nil {
+		defer recoverUpdate("chat member update")
+		b.HandleChatMember(update)
+	} else if update.InlineQuery != nil {
+		defer recoverUpdate("inline query")
+		b.HandleInlineQuery(update)
 	}
 }
 
 func (b *TeleBot) Start(update tgbotapi.Update) {
 	reply := "Welcome! This bot will first ask you for a word, and then for a sentence. It will then check if the sentence contains the word or not.\nUse /filter to define the filter word\nUse /show to search for messages"
+	// Inline searches open the private chat with /start search when they cannot be answered
+	if update.Message.CommandArguments() == "search" {
+		reply = fmt.Sprintf("Type @%s followed by words to search the messages of your groups from any chat.\n%s", b.API.Self.UserName, searchPrompt)
+	}
 	msg := tgbotapi.NewMessage(update.Message.Chat.ID, reply)
 	b.API.Send(msg)
 }
 
+// Filter asks the sender for the filter word of the chat, or of the connected group in a private chat
 func (b *TeleBot) Filter(update tgbotapi.Update) {
+	chatID := b.TargetChat(update.Message)
+
+	b.StartConversation(update.Message.Chat.ID, update.Message.From.ID, Conversation{
+		Name:  "filter",
+		Steps: []Step{{Prompt: "Write the filter word (one word only)", Validate: OneWord}},
+		Done: func(update tgbotapi.Update, answers []string) {
+			// Store filter word
+			settings := b.ChatSettings(chatID)
+			settings.FilterWord = answers[0]
+			if err := b.SaveChatSettings(chatID, settings); err != nil {
+				b.reply(update.Message, "Could not save the filter word. Please try again.")
+				return
+			}
+
+			reply := "Word received.\nPlease send a sentence in the next messages."
+			if chatID != update.Message.Chat.ID {
+				reply = "Word received.\nIt is now the filter word of the connected group."
+			}
+			msg := tgbotapi.NewMessage(update.Message.Chat.ID, reply)
+			msg.ReplyToMessageID = update.Message.MessageID
+			b.API.Send(msg)
+		},
+	})
+}
 
-	// Prompt user to enter a word
-	reply := "Write the filter word (one word only)"
+// Stop ends the update loop, the database is closed by the caller of StartListening once it returned
+func (b *TeleBot) Stop(update tgbotapi.Update) {
+	reply := "Stopping the bot."
 	msg := tgbotapi.NewMessage(update.Message.Chat.ID, reply)
 	b.API.Send(msg)
+	b.stopped = true
 }
 
-func (b *TeleBot) WordReceiver(update tgbotapi.Update) {
-	// Not waiting for filter word
-	b.WaitingForWord = false
+func (b *TeleBot) ProcessMessage(update tgbotapi.Update) {
+	settings := b.ChatSettings(update.Message.Chat.ID)
+	filterWord := settings.FilterWord
 
-	// Split the sentence into words
-	words := strings.Fields(update.Message.Text)
+	stored := NewStoredMessage(update.Message)
+	stored.Language = settings.Language
 
-	// The user input is more than one word
-	if len(words) > 1 {
-		reply := "Please provide only one word. Try /filter again."
-		msg := tgbotapi.NewMessage(update.Message.Chat.ID, reply)
-		b.API.Send(msg)
-		return
+	// Senders can search the messages of the groups they write in inline
+	if update.Message.Chat.Type != ChatPrivate && update.Message.From != nil {
+		if _, err := b.DB.TouchMember(update.Message.Chat.ID, update.Message.From.ID, time.Now()); err != nil {
+			log.Println("Error storing chat member:", err)
+		}
 	}
 
-	if !b.IsSearching {
-		b.FilterWord = words[0] // Store filter word
-		reply := "Word received.\nPlease send a sentence in the next messages."
-		msg := tgbotapi.NewMessage(update.Message.Chat.ID, reply)
-		msg.ReplyToMessageID = update.Message.MessageID
-		b.API.Send(msg)
-	} else {
-		b.SearchWord = words[0] // Store search word
-		reply := "Word received.\nSearching for the messages with this filter word."
-		msg := tgbotapi.NewMessage(update.Message.Chat.ID, reply)
-		msg.ReplyToMessageID = update.Message.MessageID
-		b.API.Send(msg)
-		b.SearchMessage(update)
+	// Check if the stored word is present in the sentence as a whole word
+	found := false
+	if filterWord != "" {
+		var start, end int
+		start, end, found = FindWord(stored.Text, filterWord)
+		if found {
+			stored.Matches = append(stored.Matches, MessageMatch{Rule: RuleFilterWord, Pattern: filterWord, Start: start, End: end})
+		}
 	}
-
-}
-
-// Stop closes the database connection
-func (b *TeleBot) Stop(update tgbotapi.Update) {
-	reply := "Stopping the bot.\nClosing database connection."
-	msg := tgbotapi.NewMessage(update.Message.Chat.ID, reply)
-	b.API.Send(msg)
-	b.CloseDB()
-}
-
-// CloseDB closes the database connection
-func (b *TeleBot) CloseDB() {
-	if b.DB != nil {
-		b.DB.Close()
+	// Trusted users are stored but not filtered
+	exempt := b.IsExempt(update.Message)
+	toReview := found && !exempt && b.ReviewsRule(update.Message.Chat.ID, RuleFilterWord)
+
+	// Store the message with the match, if any, unless it is removed from the chat for review.
+	// Subscribers only hear about the messages that stay, without holding up the next update.
+	if !toReview {
+		if err := b.DB.StoreMessage(stored); err != nil {
+			log.Println("Error storing message:", err)
+		}
+		if update.Message.Chat.Type != ChatPrivate {
+			b.QueueNotification(stored)
+		}
 	}
-}
-
-func (b *TeleBot) ProcessMessage(update tgbotapi.Update) {
 
-	// No filter word yet entered
-	if b.FilterWord == "" {
+	// No filter word yet entered, quiet chats are not reminded on every message
+	if filterWord == "" {
+		if !settings.Verbose {
+			return
+		}
 		reply := "No filter word found. Use /filter to enter one"
 		msg := tgbotapi.NewMessage(update.Message.Chat.ID, reply)
 		b.API.Send(msg)
 		return
 	}
 
-	// Split the sentence into words
-	words := strings.Fields(update.Message.Text)
-
-	// Check if the stored word is present in the sentence as a whole word
-	found := false
-	for _, word := range words {
-		if strings.EqualFold(word, b.FilterWord) {
-			found = true
-			break
-		}
+	// Quiet chats get no replies
+	if exempt {
+		return
 	}
-
-	// Store the message in the appropriate table based on whether the word is found
-	if found {
-		// Message contains the filter word, store it in messages_with_filter table
-		err := b.DB.StoreMessage(update.Message.From.ID, update.Message.Text, time.Now(), b.FilterWord, "messages_with_word")
-		if err != nil {
-			log.Println("Error storing message with filter word:", err)
-		}
-	} else {
-		// Message does not contain the filter word, store it in messages_without_filter table
-		err := b.DB.StoreMessage(update.Message.From.ID, update.Message.Text, time.Now(), b.FilterWord, "messages_without_word")
-		if err != nil {
-			log.Println("Error storing message without filter word:", err)
-		}
+	if !found && !settings.Verbose {
+		return
 	}
 
 	// Respond based on whether the word is found or not
-	if found {
+	if toReview {
+		b.SendToReview(update.Message, RuleFilterWord)
+	} else if found {
 		reply := "The sentence contains the word!"
 		msg := tgbotapi.NewMessage(update.Message.Chat.ID, reply)
 		msg.ReplyToMessageID = update.Message.MessageID
@@ -182,135 +251,159 @@ func (b *TeleBot) ProcessMessage(update tgbotapi.Update) {
 	}
 }
 
+// ContainsWord reports whether the text contains the word as a whole word, ignoring case
+func ContainsWord(text string, word string) bool {
+	_, _, found := FindWord(text, word)
+	return found
+}
+
 // Help command
 func (b *TeleBot) Help(update tgbotapi.Update) {
-	reply := "Available commands:\n" +
-		"/start - Start the bot\n" +
-		"/filter - Define a filter word\n" +
-		"/stop - Stop the bot\n" +
-		"/show - Show the stored messages" +
-		"/help - Display this help message"
+	// The list is generated from the command registry and only shows what the sender may use here
+	reply := b.HelpText(update.Message)
 	msg := tgbotapi.NewMessage(update.Message.Chat.ID, reply)
 	b.API.Send(msg)
 }
 
-// Show command
+// Show command, in a private chat it searches the messages of the connected group.
+// "/show <query>" searches right away, otherwise a menu is shown.
 func (b *TeleBot) Show(update tgbotapi.Update) {
-	// Create an inline keyboard markup
+	if text := update.Message.CommandArguments(); strings.TrimSpace(text) != "" {
+		b.SearchMessage(update, b.TargetChat(update.Message), text)
+		return
+	}
+
+	chatID := update.Message.Chat.ID
+	target := strconv.FormatInt(b.TargetChat(update.Message), 36)
+
+	// Create the button for searching the messages
+	search, err := b.callbackButton("Search messages", chatID, menuButtonTTL, CallbackShowWith, target)
+	if err != nil {
+		log.Println("Error encoding callback data:", err)
+		return
+	}
+	// Create the button for showing messages without a filter word
+	without, err := b.callbackButton("Show messages without filter word", chatID, menuButtonTTL, CallbackShowWithout, target)
+	if err != nil {
+		log.Println("Error encoding callback data:", err)
+		return
+	}
+
+	// Create an inline keyboard markup with a row for each button
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(
-		// Create a row of buttons
-		tgbotapi.NewInlineKeyboardRow(
-			// Create the first button for showing messages with a filter word
-			tgbotapi.NewInlineKeyboardButtonData("Show messages with filter word", "show_with_filter"),
-		),
-		// Create another row of buttons
-		tgbotapi.NewInlineKeyboardRow(
-			// Create the second button for showing messages without a filter word
-			tgbotapi.NewInlineKeyboardButtonData("Show messages without filter word", "show_without_filter"),
-		),
+		tgbotapi.NewInlineKeyboardRow(search),
+		tgbotapi.NewInlineKeyboardRow(without),
 	)
 
 	// Create a message with the inline keyboard markup
-	msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Choose an option:")
+	msg := tgbotapi.NewMessage(chatID, "Choose an option:")
 	msg.ReplyMarkup = keyboard
 
 	// Send the message with the menu buttons
-	_, err := b.API.Send(msg)
+	_, err = b.API.Send(msg)
 	if err != nil {
 		log.Println("Error sending message:", err)
 	}
 }
 
-// Retrieve messages with a filter word
-func (b *TeleBot) SearchMessage(update tgbotapi.Update) {
-	b.IsSearching = false
-
-	// Construct the query string by concatenating the SearchWord variable
-	query := fmt.Sprintf("SELECT sender_id, message_text, sent_date FROM messages_with_word WHERE filter_word = '%s'", b.SearchWord)
-	rows, err := b.DB.QueryRows(query)
+// Search the stored messages of a chat for the words of a query
+func (b *TeleBot) SearchMessage(update tgbotapi.Update, chatID int64, text string) {
+	query, err := ParseSearchQuery(text, b.ChatSettings(chatID).Language)
 	if err != nil {
-		log.Println("Error executing query:", err)
+		b.reply(update.Message, err.Error())
 		return
 	}
-	defer rows.Close()
-
-	// Iterate over the rows and build the message
-	var message string
-	for rows.Next() {
-		var senderID int64
-		var messageText string
-		var sentDate time.Time
-		if err := rows.Scan(&senderID, &messageText, &sentDate); err != nil {
-			log.Println("Error scanning row:", err)
-			continue
-		}
-		// Format each row into a readable message
-		message += fmt.Sprintf("Sender ID: %d\nMessage: %s\nSent Date: %s\n\n", senderID, messageText, sentDate.String())
-	}
+	b.sendSearch(update.Message.Chat.ID, chatID, query)
+}
 
-	// Check if no messages were found
-	if message == "" {
-		message = "No messages found."
+// HandleCallbackQuery handles callback queries received when a user clicks on the inline keyboard buttons
+func (b *TeleBot) HandleCallbackQuery(update tgbotapi.Update) {
+	query := update.CallbackQuery
+	if query.Message == nil {
+		return
 	}
 
-	// Send the message with the retrieved data or the "No messages found" message
-	msg := tgbotapi.NewMessage(update.Message.Chat.ID, message)
-	_, err = b.API.Send(msg)
+	// Forged, stale or foreign buttons are refused before anything runs
+	payload, err := b.callbacks.Decode(query.Data, query.Message.Chat.ID, time.Now())
 	if err != nil {
-		log.Println("Error sending message:", err)
+		log.Printf("Refused callback from %d: %v", query.From.ID, err)
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, callbackErrorText(err)))
+		return
 	}
-}
-
-// HandleCallbackQuery handles callback queries received when a user clicks on the inline keyboard buttons
-func (b *TeleBot) HandleCallbackQuery(update tgbotapi.Update) {
-	// Extract the callback data from the update
-	callbackData := update.CallbackQuery.Data
 
 	// Handle the callback data accordingly
-	switch callbackData {
-	case "show_with_filter":
-		// Prompt the user to enter the filter word
-		reply := "Please enter the filter word:"
-		msg := tgbotapi.NewMessage(update.CallbackQuery.Message.Chat.ID, reply)
-		b.API.Send(msg)
+	switch payload.Action {
+	case CallbackCaptcha:
+		b.HandleCaptchaAnswer(update, payload.Args)
+
+	case CallbackReview:
+		b.HandleReviewDecision(update, payload.Args)
+
+	case CallbackAppealEvent:
+		b.HandleAppealEvent(update, payload.Args)
+
+	case CallbackAppeal:
+		b.HandleAppealDecision(update, payload.Args)
+
+	case CallbackSettings:
+		b.HandleSettingsButton(update, payload.Args)
+
+	case CallbackConnect:
+		b.HandleConnectButton(update, payload.Args)
 
-		b.WaitingForWord = true // Set the bot's state to wait for the filter word
-		b.IsSearching = true    // Additional state variable to indicate filtering messages with a word
+	case CallbackSearchPage:
+		b.HandleSearchPageButton(update, payload.Args)
 
-	case "show_without_filter":
-		// Execute the SQL query to retrieve messages without a filter word
-		query := "SELECT sender_id, message_text, sent_date FROM messages_without_word"
-		rows, err := b.DB.QueryRows(query)
-		if err != nil {
-			log.Println("Error executing query:", err)
+	case CallbackSubscribe:
+		b.HandleSubscribeButton(update, payload.Args)
+
+	case CallbackSubscriptions:
+		b.HandleSubscriptionButton(update, payload.Args)
+
+	case CallbackLockdownLift:
+		b.HandleLockdownLift(update)
+
+	case CallbackShowWith:
+		chatID, ok := b.showTarget(query, payload.Args)
+		if !ok {
 			return
 		}
-		defer rows.Close()
-
-		// Iterate over the rows and build the message
-		var message string
-		for rows.Next() {
-			var senderID int64
-			var messageText string
-			var sentDate time.Time
-			if err := rows.Scan(&senderID, &messageText, &sentDate); err != nil {
-				log.Println("Error scanning row:", err)
-				continue
-			}
-			// Format each row into a readable message
-			message += fmt.Sprintf("Sender ID: %d\nMessage: %s\nSent Date: %s\n\n", senderID, messageText, sentDate.String())
-		}
-
-		// Check if no messages were found
-		if message == "" {
-			message = "No messages found."
+		// Ask the user who pressed the button for the words to search for
+		b.StartConversation(query.Message.Chat.ID, query.From.ID, Conversation{
+			Name:  "show",
+			Steps: []Step{{Prompt: searchPrompt, Validate: SearchQueryText}},
+			Done: func(update tgbotapi.Update, answers []string) {
+				reply := "Searching for the messages with these words."
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, reply)
+				msg.ReplyToMessageID = update.Message.MessageID
+				b.API.Send(msg)
+				b.SearchMessage(update, chatID, answers[0])
+			},
+		})
+
+	case CallbackShowWithout:
+		chatID, ok := b.showTarget(query, payload.Args)
+		if !ok {
+			return
 		}
+		// Browse the messages no rule matched
+		b.sendSearch(query.Message.Chat.ID, chatID, SearchQuery{Unmatched: true})
+	}
+}
 
-		// Send the message with the retrieved data
-		msg := tgbotapi.NewMessage(update.CallbackQuery.Message.Chat.ID, message)
-		_, err = b.API.Send(msg)
-		if err != nil {
-			log.Println("Error sending message:", err)
-		}
+// showTarget returns the chat whose messages a /show button searches, if the user pressing it may see them
+func (b *TeleBot) showTarget(query *tgbotapi.CallbackQuery, args []string) (int64, bool) {
+	if len(args) != 1 {
+		return 0, false
+	}
+	chatID, err := strconv.ParseInt(args[0], 36, 64)
+	if err != nil {
+		return 0, false
+	}
+	if !b.HasRole(chatID, query.From.ID, RoleModerator) {
+		b.API.Request(tgbotapi.NewCallbackWithAlert(query.ID, "Only moderators can search the messages."))
+		return 0, false
 	}
+	b.API.Request(tgbotapi.NewCallback(query.ID, ""))
+	return chatID, true
 }
@@ -0,0 +1,93 @@
+package structs
+
+import (
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func groupMessage(id int, text string) tgbotapi.Update {
+	return tgbotapi.Update{Message: &tgbotapi.Message{
+		MessageID: id,
+		From:      &tgbotapi.User{ID: 7, FirstName: "Ali"},
+		Chat:      &tgbotapi.Chat{ID: -100123, Type: ChatSupergroup, Title: "Group"},
+		Text:      text,
+	}}
+}
+
+func storedCount(t *testing.T, bot *TeleBot, word string) int {
+	t.Helper()
+	count, err := bot.DB.CountMessages([]int64{-100123}, SearchQuery{Terms: []string{word}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestProcessMessageStores(t *testing.T) {
+	bot, _ := newFakeBot(t)
+
+	// Messages are searchable before a filter word is entered
+	bot.ProcessMessage(groupMessage(1, "early message"))
+	if storedCount(t, bot, "early") != 1 {
+		t.Error("message without a filter word not stored")
+	}
+	if chats, _ := bot.DB.MemberChats(7); len(chats) != 1 {
+		t.Errorf("member chats = %v, want the group", chats)
+	}
+
+	settings := bot.ChatSettings(-100123)
+	settings.FilterWord = "spam"
+	settings.Review = ReviewSettings{ChatID: -100999, Rules: []string{RuleFilterWord}}
+	if err := bot.SaveChatSettings(-100123, settings); err != nil {
+		t.Fatal(err)
+	}
+
+	// Messages removed for review must not be found by searches
+	bot.ProcessMessage(groupMessage(2, "this is spam"))
+	if storedCount(t, bot, "spam") != 0 {
+		t.Error("message sent to review stored")
+	}
+	bot.ProcessMessage(groupMessage(3, "this is fine"))
+	if storedCount(t, bot, "fine") != 1 {
+		t.Error("kept message not stored")
+	}
+}
+
+func TestProcessMessageNotifiesSubscribers(t *testing.T) {
+	bot, api := newFakeBot(t)
+	api.setResult("getChatMember", `{"user":{"id":8},"status":"member"}`, 0)
+	bot.DB.TouchMember(-100123, 8, time.Now())
+	if _, err := bot.DB.CreateSubscription(Subscription{UserID: 8, ChatID: -100123, ChatTitle: "Group", Query: "news"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Chats without a filter word are watched too
+	bot.ProcessMessage(groupMessage(1, "big news today"))
+	select {
+	case message := <-bot.notifications:
+		bot.NotifySubscribers(message)
+	default:
+		t.Fatal("message not queued for the subscribers")
+	}
+
+	alerts := 0
+	for _, call := range api.called("sendMessage") {
+		if call.Params["chat_id"] == "8" {
+			alerts++
+		}
+	}
+	if alerts != 1 {
+		t.Errorf("alerts sent to the subscriber = %d, want 1", alerts)
+	}
+}
+
+func TestProcessMessageSkipsChatsWithoutSubscriptions(t *testing.T) {
+	bot, _ := newFakeBot(t)
+
+	bot.ProcessMessage(groupMessage(1, "big news today"))
+	if len(bot.notifications) != 0 {
+		t.Error("message queued for a chat nobody subscribed to")
+	}
+}